@@ -19,14 +19,25 @@ func main() {
 
 	flag.Parse()
 
-	pop := multicell.NewPopulation(*ncellsP, *maxpopP)
+	settings := multicell.DefaultSettings()
+	settings.MaxPop = *maxpopP
+	settings.NCells = *ncellsP
+	pop := multicell.NewPopulation(multicell.NewModel(settings))
+	var model *multicell.Model
 	if *jsongzinP != "" {
-		pop.ImportPopGz(*jsongzinP)
-		multicell.SetParams(pop.Params)
+		err := pop.ImportPopGz(*jsongzinP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		model = multicell.NewModel(pop.Params)
 	} else {
 		flag.PrintDefaults()
 		log.Fatal("Specify the input JSON file with -jsonin=filename.")
 	}
+	if err := pop.RequireDistinctGenomes(); err != nil { //Anc and Nov bodies develop one genome
+		fmt.Printf("# %v (dp is the reaction norm of a single genotype)\n", err)
+	}
+	Nenv := model.NEnv
 
 	env0 := multicell.FlattenEnvs(pop.AncEnvs)
 	env1 := multicell.FlattenEnvs(pop.NovEnvs)
@@ -34,15 +45,15 @@ func main() {
 	denv := multicell.NewVec(dim)
 	multicell.DiffVecs(denv, env1, env0)
 
-	e0 := pop.GetFlatStateVec("E", 0)
-	e1 := pop.GetFlatStateVec("E", 1)
+	e0 := pop.GetFlatStateVec("E", 0, 0, Nenv)
+	e1 := pop.GetFlatStateVec("E", 1, 0, Nenv)
 	me0 := multicell.GetMeanVec(e0)
 	me1 := multicell.GetMeanVec(e1)
 	de := multicell.NewVec(dim)
 	multicell.DiffVecs(de, me1, me0)
 
-	p0 := pop.GetFlatStateVec("P", 0)
-	p1 := pop.GetFlatStateVec("P", 1)
+	p0 := pop.GetFlatStateVec("P", 0, 0, Nenv)
+	p1 := pop.GetFlatStateVec("P", 1, 0, Nenv)
 	mp0 := multicell.GetMeanVec(p0)
 	mp1 := multicell.GetMeanVec(p1)
 	dp := multicell.NewVec(dim)
@@ -106,7 +117,7 @@ func main() {
 	Project("mixPP", dirE, dirP, mixp, mixp, true, true)
 	Project("mixPE", dirE, dirP, mixp, mixe, true, true)
 
-	LinearResponse(&pop)
+	LinearResponse(&pop, Nenv)
 }
 
 func Project(label string, dir0, dir1 *mat.VecDense, data0, data1 [][]float64, sub0, sub1 bool) {
@@ -115,16 +126,16 @@ func Project(label string, dir0, dir1 *mat.VecDense, data0, data1 [][]float64, s
 	multicell.ProjectSVD(label, dir0, dir1, data0, data1, mean0, mean1, ccmat, vals, U, V)
 }
 
-func LinearResponse(pop *multicell.Population) {
+func LinearResponse(pop *multicell.Population, Nenv int) {
 	env0 := multicell.FlattenEnvs(pop.AncEnvs)
 	env1 := multicell.FlattenEnvs(pop.NovEnvs)
 	dim := len(env0)
 	denv := multicell.NewVec(dim)
 	multicell.DiffVecs(denv, env1, env0)
 
-	fe0 := pop.GetFlatStateVec("E", 0)
-	fp0 := pop.GetFlatStateVec("P", 0)
-	fp1 := pop.GetFlatStateVec("P", 1)
+	fe0 := pop.GetFlatStateVec("E", 0, 0, Nenv)
+	fp0 := pop.GetFlatStateVec("P", 0, 0, Nenv)
+	fp1 := pop.GetFlatStateVec("P", 1, 0, Nenv)
 	p0_ave, _, pe_cov := multicell.GetCrossCov(fp0, fe0, true, true)
 	p1_ave := multicell.GetMeanVec(fp1)
 	dp := multicell.NewVec(dim)
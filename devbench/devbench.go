@@ -0,0 +1,65 @@
+package main
+
+// Benchmark of development: individuals developed per second for the
+// standard presets.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/arkinjo/evodevo/multicell"
+)
+
+func main() {
+	presetsP := flag.String("presets", strings.Join(multicell.Presets, ","), "comma-separated list of presets")
+	maxpopP := flag.Int("maxpop", 1000, "number of individuals")
+	ngenP := flag.Int("ngen", 3, "number of generations to develop (after one for warm-up)")
+	procsP := flag.Int("procs", 0, "GOMAXPROCS (0: default)")
+	seedP := flag.Int("seed", 13, "random seed")
+	flag.Parse()
+
+	if *procsP > 0 {
+		runtime.GOMAXPROCS(*procsP)
+	}
+
+	fmt.Printf("#GOMAXPROCS=%d maxpop=%d ngen=%d\n", runtime.GOMAXPROCS(0), *maxpopP, *ngenP)
+	fmt.Println("#Preset\tNGenes\tIndivs/s\tSec/gen  \tMB/gen  \tNDevStep(last gen)")
+	for _, name := range strings.Split(*presetsP, ",") {
+		s := multicell.DefaultSettings()
+		s.MaxPop = *maxpopP
+		err := s.SetPreset(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		model := multicell.NewModel(s)
+		model.SetSeed(int64(*seedP))
+		model.SetSeedCue(int64(*seedP))
+		pop := multicell.NewPopulation(model)
+		pop.RandomizeGenome(model)
+		pop.SetRandomNovEnvs(model)
+		pop.ChangeEnvs(model, s.NEnv/2)
+
+		pop.DevPop(model, 0) // warm-up
+		var ms0, ms1 runtime.MemStats
+		runtime.ReadMemStats(&ms0)
+		t0 := time.Now()
+		for gen := 1; gen <= *ngenP; gen++ {
+			pop.DevPop(model, gen)
+		}
+		dt := time.Since(t0).Seconds()
+		runtime.ReadMemStats(&ms1)
+		ndev := 0
+		for _, indiv := range pop.Indivs {
+			ndev += indiv.Bodies[multicell.INovEnv].NDevStep
+		}
+
+		ngen := float64(*ngenP)
+		nindiv := ngen * float64(len(pop.Indivs))
+		mb := float64(ms1.TotalAlloc-ms0.TotalAlloc) / (1 << 20)
+		fmt.Printf("%s\t%d\t%e\t%e\t%e\t%e\n", name, s.NGenes, nindiv/dt, dt/ngen, mb/ngen, float64(ndev)/float64(len(pop.Indivs)))
+	}
+}
@@ -2,4 +2,7 @@ module github.com/arkinjo/evodevo
 
 go 1.15
 
-require gonum.org/v1/gonum v0.11.0 // indirect
+require (
+	golang.org/x/exp v0.0.0-20191002040644-a1355ae1e2c3
+	gonum.org/v1/gonum v0.11.0
+)
@@ -30,10 +30,12 @@ func main() {
 	//pgfilenamePtr := flag.String("PG_file", "phenogeno", "Filename of projected phenotypes and genotypes")
 	gvarfilenamePtr := flag.String("GVar_file", "gvar", "Filename of genetic variances")
 	jsongzinPtr := flag.String("jsongzin", "", "basename of JSON files")
+	archivePtr := flag.String("archive", "", "archive file of populations (instead of JSON files)")
+	epochPtr := flag.Int("epoch", 1, "epoch to read from archive")
 
 	flag.Parse()
 
-	settings := multicell.CurrentSettings()
+	settings := multicell.DefaultSettings()
 	settings.MaxPop = *maxpopP
 	settings.MaxDevStep = *maxdevstepP
 	settings.NGenes = *ngenesP
@@ -49,8 +51,18 @@ func main() {
 
 	json_in := *jsongzinPtr
 
-	if json_in == "" {
-		log.Fatal("Must specify JSON input file.")
+	archive_in := *archivePtr
+	if json_in == "" && archive_in == "" {
+		log.Fatal("Must specify JSON input file or archive.")
+	}
+	var archive *multicell.ArchiveReader
+	var err error
+	if archive_in != "" {
+		archive, err = multicell.OpenArchive(archive_in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer archive.Close()
 	}
 	/*
 		if refgen1 == "" {
@@ -70,17 +82,28 @@ func main() {
 
 	//log.Println("Dumping start \n")
 	for gen := 1; gen <= epochlength; gen++ {
-		jfilename := fmt.Sprintf("%s_%3.3d.json.gz", json_in, gen)
-		pop := multicell.NewPopulation(settings)
-		pop.ImportPopGz(jfilename)
+		var pop multicell.Population
+		if archive != nil {
+			pop, err = archive.Read(*epochPtr, gen)
+		} else {
+			jfilename := fmt.Sprintf("%s_%3.3d.json.gz", json_in, gen)
+			pop = multicell.NewPopulation(multicell.NewModel(settings))
+			err = pop.ImportPopGz(jfilename)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
 		settings = pop.Params
-		multicell.SetParams(settings)
+		model := multicell.NewModel(settings)
+		if gen == 1 && settings.SharedGenome {
+			fmt.Println("Shared genome: Anc and Nov genomes are the same")
+		}
 
-		AncPopGVecs := pop.GetFlatGenome(multicell.IAncEnv)
+		AncPopGVecs := pop.GetFlatGenome(model, multicell.IAncEnv)
 		SSEVec := multicell.GetVarVec(AncPopGVecs)
 		SS_Anc = multicell.SumVec(SSEVec)
 
-		NovPopGVecs := pop.GetFlatGenome(multicell.INovEnv)
+		NovPopGVecs := pop.GetFlatGenome(model, multicell.INovEnv)
 		SSEVec = multicell.GetVarVec(NovPopGVecs)
 		SS_Nov = multicell.SumVec(SSEVec)
 
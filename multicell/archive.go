@@ -0,0 +1,588 @@
+package multicell
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// Archive of population snapshots, one file per run.
+/*
+   Layout of an archive file:
+     header   "EVOARC02" ("EVOARC01": genomes as arrays of maps)
+     records  each = record header + flate(gob(record))
+     index    "EVIX", count, {epoch, gen, offset, length} * count
+     trailer  offset of index (8 bytes), "EVOAREND"
+   Records are compressed independently, so any generation can be read
+   without decoding the others. If the writer was killed before writing the
+   index, the index is rebuilt by scanning the record headers.
+
+   A record is either a full Population ("EVRC") or, if the writer is given
+   a keyframe interval, a delta from the preceding record ("EVRD"; see
+   delta.go). Full records (keyframes) are written at the start of each
+   epoch and every keyframe generations, which bounds the work of reading
+   a generation at random.
+*/
+
+const (
+	archiveMagic  = "EVOARC02"
+	legacyMagic   = "EVOARC01" // Spmat as array of maps (before CSR)
+	archiveEnd    = "EVOAREND"
+	indexMagic    = "EVIX"
+	recordMagic   = "EVRC"
+	deltaMagic    = "EVRD"
+	recHeaderSize = 4 + 4 + 4 + 4 + 4 // magic, epoch, gen, length, crc32
+)
+
+var ErrNotArchive = errors.New("not a population archive")
+
+type ArchiveEntry struct {
+	Epoch, Gen int
+	Offset     int64 // Offset of the record header
+	Length     int   // Length of the compressed record
+	Delta      bool  // Delta from the preceding record
+}
+
+type ArchiveWriter struct {
+	file    *os.File
+	offset  int64
+	entries []ArchiveEntry
+
+	keyframe   int      // Interval of full records; 0: full records only
+	nsince     int      // Number of delta records since the last full record
+	parents    []Genome // Nov genomes of the last record
+	prevParams Settings
+	prevEpoch  int
+	prevGen    int
+}
+
+type ArchiveReader struct {
+	file    *os.File
+	entries []ArchiveEntry
+	legacy  bool // Written before CSR sparse matrices
+	cache   int  // Entry whose Nov genomes are in parents; -1 if none
+	parents []Genome
+}
+
+// Creates a new archive (truncating an existing file).
+func CreateArchive(filename string) (*ArchiveWriter, error) {
+	fout, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fout.Write([]byte(archiveMagic))
+	if err != nil {
+		fout.Close()
+		return nil, err
+	}
+
+	return &ArchiveWriter{file: fout, offset: int64(len(archiveMagic))}, nil
+}
+
+// Opens an existing archive to append more records. Records of epochs after
+// maxEpoch are discarded (as when resuming from a checkpoint); a negative
+// maxEpoch keeps all records.
+func AppendArchive(filename string, maxEpoch int) (*ArchiveWriter, error) {
+	fout, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readIndex(fout)
+	if err == nil && isLegacyArchive(fout) {
+		err = errors.New("archive written by an older version; cannot append")
+	}
+	if err != nil {
+		fout.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	offset := int64(len(archiveMagic))
+	kept := make([]ArchiveEntry, 0, len(entries))
+	for _, e := range entries {
+		if maxEpoch >= 0 && e.Epoch > maxEpoch {
+			break
+		}
+		kept = append(kept, e)
+		offset = e.Offset + recHeaderSize + int64(e.Length)
+	}
+	err = fout.Truncate(offset) // Drops the old index as well.
+	if err == nil {
+		_, err = fout.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		fout.Close()
+		return nil, err
+	}
+
+	return &ArchiveWriter{file: fout, offset: offset, entries: kept}, nil
+}
+
+// Stores generations as deltas from their parents, with a full record at
+// the start of each epoch and every n generations. n = 0 (the default)
+// stores full records only.
+func (ar *ArchiveWriter) SetKeyframe(n int) {
+	ar.keyframe = n
+}
+
+func encodeRecord(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	zipper, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	err = gob.NewEncoder(zipper).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	err = zipper.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(data []byte, v interface{}) error {
+	unzipper := flate.NewReader(bytes.NewReader(data))
+	defer unzipper.Close()
+	err := gob.NewDecoder(unzipper).Decode(v)
+	if err != nil {
+		return readError(err)
+	}
+	return nil
+}
+
+// Whether pop can be stored as a delta from the last record.
+func (ar *ArchiveWriter) isDelta(pop *Population) bool {
+	if ar.keyframe <= 0 || ar.parents == nil || ar.nsince+1 >= ar.keyframe {
+		return false
+	}
+	if pop.Epoch != ar.prevEpoch || pop.Gen != ar.prevGen+1 ||
+		pop.Params.NGenes != ar.prevParams.NGenes || pop.Params.NEnv != ar.prevParams.NEnv {
+		return false
+	}
+	for _, indiv := range pop.Indivs {
+		if indiv.DadId < 0 || indiv.DadId >= len(ar.parents) || indiv.MomId < 0 || indiv.MomId >= len(ar.parents) ||
+			len(indiv.Bodies) != pop.Params.NEnvs {
+			return false
+		}
+	}
+	return true
+}
+
+// Appends a snapshot of the population; indexed by (pop.Epoch, pop.Gen).
+// seed is the run seed (Model.Seed) with which delta records are developed
+// again on reading. pop itself is not changed.
+func (ar *ArchiveWriter) Append(pop *Population, seed int64) error {
+	pop1 := *pop.stored()
+	pop1.FormatVersion = PopFormatVersion
+	magic := recordMagic
+	var rec interface{} = &pop1
+	delta := ar.isDelta(pop)
+	if delta {
+		d := encodeDelta(&pop1, ar.parents, seed)
+		rec = &d
+		magic = deltaMagic
+	}
+	data, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, recHeaderSize)
+	copy(header, magic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(int32(pop.Epoch)))
+	binary.LittleEndian.PutUint32(header[8:], uint32(int32(pop.Gen)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(data))
+	_, err = ar.file.Write(header)
+	if err == nil {
+		_, err = ar.file.Write(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	ar.entries = append(ar.entries, ArchiveEntry{pop.Epoch, pop.Gen, ar.offset, len(data), delta})
+	ar.offset += recHeaderSize + int64(len(data))
+
+	if ar.keyframe > 0 {
+		if delta {
+			ar.nsince++
+		} else {
+			ar.nsince = 0
+		}
+		ar.parents = novGenomes(pop.Indivs)
+		for i := range ar.parents {
+			ar.parents[i] = ar.parents[i].Copy()
+		}
+		ar.prevParams = pop.Params
+		ar.prevEpoch = pop.Epoch
+		ar.prevGen = pop.Gen
+	}
+	return nil
+}
+
+// Writes the index and closes the archive.
+func (ar *ArchiveWriter) Close() error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(indexMagic)
+	binary.Write(buf, binary.LittleEndian, uint32(len(ar.entries)))
+	for _, e := range ar.entries {
+		binary.Write(buf, binary.LittleEndian, int32(e.Epoch))
+		binary.Write(buf, binary.LittleEndian, int32(e.Gen))
+		binary.Write(buf, binary.LittleEndian, uint64(e.Offset))
+		binary.Write(buf, binary.LittleEndian, uint32(e.Length))
+	}
+	binary.Write(buf, binary.LittleEndian, uint64(ar.offset))
+	buf.WriteString(archiveEnd)
+
+	_, err := ar.file.Write(buf.Bytes())
+	if err != nil {
+		ar.file.Close()
+		return err
+	}
+	return ar.file.Close()
+}
+
+func isLegacyArchive(f *os.File) bool {
+	magic := make([]byte, len(legacyMagic))
+	_, err := f.ReadAt(magic, 0)
+	return err == nil && string(magic) == legacyMagic
+}
+
+// Reads the index at the end of the file, or rebuilds it by scanning.
+func readIndex(f *os.File) ([]ArchiveEntry, error) {
+	magic := make([]byte, len(archiveMagic))
+	_, err := f.ReadAt(magic, 0)
+	if err != nil || (string(magic) != archiveMagic && string(magic) != legacyMagic) {
+		return nil, ErrNotArchive
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+
+	trailer := make([]byte, 8+len(archiveEnd))
+	if size >= int64(len(archiveMagic)+len(trailer)) {
+		_, err = f.ReadAt(trailer, size-int64(len(trailer)))
+		if err != nil {
+			return nil, err
+		}
+		if string(trailer[8:]) == archiveEnd {
+			offset := int64(binary.LittleEndian.Uint64(trailer))
+			index := io.NewSectionReader(f, offset, size-int64(len(trailer))-offset)
+			entries, err := decodeIndex(index)
+			if err == nil {
+				return entries, markDeltas(f, entries)
+			}
+		}
+	}
+
+	return scanRecords(f, size)
+}
+
+func decodeIndex(r io.Reader) ([]ArchiveEntry, error) {
+	magic := make([]byte, len(indexMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != indexMagic {
+		return nil, ErrNotArchive
+	}
+	var n uint32
+	err = binary.Read(r, binary.LittleEndian, &n)
+	if err != nil {
+		return nil, readError(err)
+	}
+	entries := make([]ArchiveEntry, n)
+	for i := range entries {
+		var rec struct {
+			Epoch, Gen int32
+			Offset     uint64
+			Length     uint32
+		}
+		err = binary.Read(r, binary.LittleEndian, &rec)
+		if err != nil {
+			return nil, readError(err)
+		}
+		entries[i] = ArchiveEntry{int(rec.Epoch), int(rec.Gen), int64(rec.Offset), int(rec.Length), false}
+	}
+	return entries, nil
+}
+
+// Sets the kinds of the entries from their record headers.
+func markDeltas(f *os.File, entries []ArchiveEntry) error {
+	magic := make([]byte, len(deltaMagic))
+	for i, e := range entries {
+		_, err := f.ReadAt(magic, e.Offset)
+		if err != nil {
+			return readError(err)
+		}
+		entries[i].Delta = string(magic) == deltaMagic
+	}
+	return nil
+}
+
+// Rebuilds the index of an archive whose writer did not finish.
+func scanRecords(f *os.File, size int64) ([]ArchiveEntry, error) {
+	entries := make([]ArchiveEntry, 0)
+	header := make([]byte, recHeaderSize)
+	offset := int64(len(archiveMagic))
+	for offset+recHeaderSize <= size {
+		_, err := f.ReadAt(header, offset)
+		magic := string(header[0:4])
+		if err != nil || (magic != recordMagic && magic != deltaMagic) {
+			break
+		}
+		length := int(binary.LittleEndian.Uint32(header[12:]))
+		if offset+recHeaderSize+int64(length) > size {
+			break // Incomplete last record.
+		}
+		epoch := int(int32(binary.LittleEndian.Uint32(header[4:])))
+		gen := int(int32(binary.LittleEndian.Uint32(header[8:])))
+		entries = append(entries, ArchiveEntry{epoch, gen, offset, length, magic == deltaMagic})
+		offset += recHeaderSize + int64(length)
+	}
+	return entries, nil
+}
+
+func OpenArchive(filename string) (*ArchiveReader, error) {
+	fin, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readIndex(fin)
+	if err != nil {
+		fin.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return &ArchiveReader{file: fin, entries: entries, legacy: isLegacyArchive(fin), cache: -1}, nil
+}
+
+func (ar *ArchiveReader) Close() error {
+	return ar.file.Close()
+}
+
+// Entries of the archive in the order they were written.
+func (ar *ArchiveReader) Entries() []ArchiveEntry {
+	return ar.entries
+}
+
+// Reads and checks the (compressed) data of a record.
+func (ar *ArchiveReader) readRecord(e ArchiveEntry) ([]byte, error) {
+	header := make([]byte, recHeaderSize)
+	_, err := ar.file.ReadAt(header, e.Offset)
+	if err != nil {
+		return nil, readError(err)
+	}
+	data := make([]byte, e.Length)
+	_, err = ar.file.ReadAt(data, e.Offset+recHeaderSize)
+	if err != nil {
+		return nil, readError(err)
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[16:]) {
+		return nil, fmt.Errorf("archive record (epoch %d, gen %d): checksum mismatch", e.Epoch, e.Gen)
+	}
+	return data, nil
+}
+
+// Nov genomes of entry i, rebuilt from the last full record before it.
+// Cell states are not developed.
+func (ar *ArchiveReader) genomes(i int) ([]Genome, error) {
+	if i < 0 {
+		return nil, errors.New("archive: delta record without preceding record")
+	}
+	if ar.cache == i {
+		return ar.parents, nil
+	}
+	k := i
+	for k >= 0 && ar.entries[k].Delta && k != ar.cache {
+		k--
+	}
+	if k < 0 {
+		return nil, fmt.Errorf("archive: delta record (epoch %d, gen %d) without full record before it",
+			ar.entries[i].Epoch, ar.entries[i].Gen)
+	}
+
+	var parents []Genome
+	for j := k; j <= i; j++ {
+		e := ar.entries[j]
+		if j == ar.cache {
+			parents = ar.parents
+			continue
+		}
+		data, err := ar.readRecord(e)
+		if err != nil {
+			return nil, err
+		}
+		if e.Delta {
+			var d deltaRecord
+			err = decodeRecord(data, &d)
+			if err != nil {
+				return nil, err
+			}
+			upgradeSettings(d.FormatVersion, &d.Params)
+			m, err := NewModelChecked(d.Params)
+			if err != nil {
+				return nil, fmt.Errorf("Settings: %w", err)
+			}
+			indivs, err := d.indivs(m, parents)
+			if err != nil {
+				return nil, err
+			}
+			parents = novGenomes(indivs)
+		} else {
+			pop, err := ar.decodeFull(data)
+			if err != nil {
+				return nil, err
+			}
+			parents = novGenomes(pop.Indivs)
+		}
+		if parents == nil {
+			return nil, fmt.Errorf("archive record (epoch %d, gen %d): Ids of individuals are not their positions", e.Epoch, e.Gen)
+		}
+	}
+	ar.cache, ar.parents = i, parents
+
+	return parents, nil
+}
+
+// Decodes a full record.
+func (ar *ArchiveReader) decodeFull(data []byte) (Population, error) {
+	var pop Population
+	err := decodeRecord(data, &pop)
+	if err != nil || !ar.legacy {
+		return pop, err
+	}
+
+	// gob skips the Mat field of old records; decode the genomes again.
+	var old legacyPopulation
+	err = decodeRecord(data, &old)
+	if err != nil {
+		return pop, err
+	}
+	if len(old.Indivs) != len(pop.Indivs) {
+		return pop, &DimensionError{"legacy archive individuals", len(old.Indivs), len(pop.Indivs)}
+	}
+	for i, indiv := range old.Indivs {
+		if len(indiv.Bodies) != len(pop.Indivs[i].Bodies) {
+			return pop, &DimensionError{"legacy archive bodies", len(indiv.Bodies), len(pop.Indivs[i].Bodies)}
+		}
+		for b, body := range indiv.Bodies {
+			genome := body.Genome.genome()
+			pop.Indivs[i].Bodies[b].Genome = &genome
+		}
+	}
+	return pop, nil
+}
+
+func (ar *ArchiveReader) readEntry(i int) (Population, error) {
+	e := ar.entries[i]
+	data, err := ar.readRecord(e)
+	if err != nil {
+		return Population{}, err
+	}
+
+	var pop Population
+	if e.Delta {
+		var d deltaRecord
+		err = decodeRecord(data, &d)
+		if err != nil {
+			return pop, err
+		}
+		upgradeSettings(d.FormatVersion, &d.Params)
+		parents, err := ar.genomes(i - 1)
+		if err != nil {
+			return pop, err
+		}
+		pop, err = d.population(parents)
+		if err != nil {
+			return pop, err
+		}
+	} else {
+		pop, err = ar.decodeFull(data)
+		if err != nil {
+			return pop, err
+		}
+	}
+	err = pop.checkVersion()
+	if err != nil {
+		return pop, err
+	}
+	pop.upgrade()
+	err = pop.Validate()
+	if err != nil {
+		return pop, err
+	}
+
+	if i+1 < len(ar.entries) && ar.entries[i+1].Delta { // Keep genomes for reading the next generation.
+		ar.cache, ar.parents = i, novGenomes(pop.Indivs)
+		for k := range ar.parents {
+			ar.parents[k] = ar.parents[k].Copy()
+		}
+	}
+	return pop, nil
+}
+
+// Reads the snapshot of a generation. If the same (epoch, gen) was written
+// more than once, the last one is returned.
+func (ar *ArchiveReader) Read(epoch, gen int) (Population, error) {
+	for i := len(ar.entries) - 1; i >= 0; i-- {
+		e := ar.entries[i]
+		if e.Epoch == epoch && e.Gen == gen {
+			return ar.readEntry(i)
+		}
+	}
+	return Population{}, fmt.Errorf("archive: no record for epoch %d, gen %d", epoch, gen)
+}
+
+// Epochs present in the archive, in increasing order.
+func (ar *ArchiveReader) Epochs() []int {
+	seen := make(map[int]bool)
+	epochs := make([]int, 0)
+	for _, e := range ar.entries {
+		if !seen[e.Epoch] {
+			seen[e.Epoch] = true
+			epochs = append(epochs, e.Epoch)
+		}
+	}
+	sort.Ints(epochs)
+	return epochs
+}
+
+// Genomes of archives written before CSR sparse matrices (decoding only).
+type legacySpmat struct {
+	Ncol int
+	Mat  []map[int]float64
+}
+
+type legacyGenome struct {
+	E, F, G, H, J, P legacySpmat
+}
+
+type legacyPopulation struct {
+	Indivs []struct {
+		Bodies []struct {
+			Genome legacyGenome
+		}
+	}
+}
+
+func (lg *legacyGenome) genome() Genome {
+	return Genome{
+		spmatFromMaps(lg.E.Ncol, lg.E.Mat),
+		spmatFromMaps(lg.F.Ncol, lg.F.Mat),
+		spmatFromMaps(lg.G.Ncol, lg.G.Mat),
+		spmatFromMaps(lg.H.Ncol, lg.H.Mat),
+		spmatFromMaps(lg.J.Ncol, lg.J.Mat),
+		spmatFromMaps(lg.P.Ncol, lg.P.Mat),
+		nil,
+	}
+}
@@ -0,0 +1,115 @@
+package multicell
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Snapshots of generations 1, 2 of epochs 1, 2.
+func archiveSnapshots() []Population {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	m := NewModel(s)
+	var pops []Population
+	for epoch := 1; epoch <= 2; epoch++ {
+		for gen := 1; gen <= 2; gen++ {
+			pop := NewPopulation(m)
+			pop.RandomizeGenome(m)
+			pop.SetRandomNovEnvs(m)
+			pop.Epoch = epoch
+			pop.Gen = gen
+			pops = append(pops, pop)
+		}
+	}
+	return pops
+}
+
+func writeArchive(t *testing.T, filename string, pops []Population, close bool) {
+	t.Helper()
+	ar, err := CreateArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range pops {
+		if err := ar.Append(&pops[i], 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if close {
+		err = ar.Close()
+	} else { //as if the writer was killed
+		err = ar.file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks that the archive holds exactly pops.
+func checkArchive(t *testing.T, filename string, pops []Population) {
+	t.Helper()
+	ar, err := OpenArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+	if len(ar.Entries()) != len(pops) {
+		t.Fatalf("%d entries, want %d", len(ar.Entries()), len(pops))
+	}
+	for _, want := range pops {
+		got, err := ar.Read(want.Epoch, want.Gen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("epoch %d, gen %d: snapshot differs", want.Epoch, want.Gen)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	pops := archiveSnapshots()
+	filename := filepath.Join(t.TempDir(), "run.evar")
+
+	writeArchive(t, filename, pops, true)
+	checkArchive(t, filename, pops)
+
+	writeArchive(t, filename, pops, false)
+	checkArchive(t, filename, pops)
+
+	// Cut the last record in half.
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, err := OpenArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := ar.Entries()[len(pops)-1]
+	ar.Close()
+	if err := os.Truncate(filename, info.Size()-int64(last.Length)/2); err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, filename, pops[:len(pops)-1])
+
+	// Resume after epoch 1 and write epoch 2 again.
+	aw, err := AppendArchive(filename, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < len(pops); i++ {
+		if err := aw.Append(&pops[i], 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(t, filename, pops)
+}
@@ -4,43 +4,14 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"math/rand"
+	"sort"
+	"strings"
 
 	"gonum.org/v1/gonum/mat"
 )
 
-var maxPop int = 200 // population size
-var maxDevStep = 200 // Maximum steps for development.
-var ngenes int = 200 // number of genes
-var nenv int = 200   // number of environmental cues/traits per face
-var nsel int = 40    // number of environmental cues/traits per face FOR SELECTION
-var ncells int = 1   //number of cell types
-
-var with_cue bool = true // with or without environmental cues.
-var pheno_feedback bool = false
-var withE bool = false // = with_cue || pheno_feedback
-var withF bool = true  // Epigenetic marker layer
-var withH bool = true  // Higher order complexes layer
-var withJ bool = false
-var devNoise float64 = 0.05 // noise strength
-var mutRate float64 = 0.005 // mutation rate
-
-// Decay rates
-var tauF float64 = 0.2
-var tauG float64 = 1.0
-var tauH float64 = 1.0
-
-// Damping rate of environmental cues
-var dampFactorE float64 = 1.0
-
 // matrix densities
 const defaultDensity float64 = 0.02 // = 4 / 200 (4 inputs per row)
-var DensityE float64 = defaultDensity
-var DensityF float64 = defaultDensity
-var DensityG float64 = defaultDensity
-var DensityH float64 = defaultDensity
-var DensityJ float64 = defaultDensity
-var DensityP float64 = defaultDensity
 
 type Settings struct {
 	MaxPop     int // Maximum number of individuals in population
@@ -65,140 +36,199 @@ type Settings struct {
 	DensityH   float64
 	DensityJ   float64
 	DensityP   float64
+
+	// Formerly constants; files before format version 2 get the defaults.
+	CueMag           float64 // each trait is +/-CueMag
+	EpsDev           float64 // Convergence criterion of development.
+	CCStep           float64 // Number of steady steps for convergence
+	BaseSelStrength  float64 // selection strength; to be normalized by number of cells
+	SelDevStep       float64 // Developmental steps for selection
+	MinWagnerFitness float64 // Zero fitness individuals that don't converge can still reproduce
+
+	// Activation functions of the layers (see activations); since format version 3.
+	ActF, ActG, ActH, ActP string
+
+	// Weights (see weights.go); since format version 4.
+	WeightModel string  // ternary, gaussian, or lognormal
+	WeightSD    float64 // Spread of weight magnitudes (not used by ternary)
+	PerturbRate float64 // Probability of perturbing a nonzero weight per generation
+	PerturbStep float64 // Size of perturbations
+
+	// Mutation rates of the matrices; 0: MutRate. MutRates sets those of
+	// any matrices by name (also of Layers), overriding MutRateE ... P.
+	// Frozen matrices (letters of EFGHJP, e.g. "FGHJP", or comma-separated
+	// names, e.g. "P,Q") are neither mutated nor crossed over.
+	MutRateE, MutRateF, MutRateG, MutRateH, MutRateJ, MutRateP float64
+	Frozen                                                     string
+
+	MutRates map[string]float64 `json:",omitempty"` // Since format version 15
+
+	// Cell-cell signaling (see layers.go): layer whose state the cells of a
+	// body send to each other ("": none), and the density of S in the EFGHJP
+	// model; since format version 6.
+	Signal   string `json:",omitempty"`
+	DensityS float64
+	NSignal  int `json:",omitempty"` // Number of units of the Signal layer sent (0: all)
+
+	// Lattice of cells and morphogens (see lattice.go); since format version 7.
+	Lattice     int     // 0: none; 1: chain of NCells cells; 2: grid of LatticeW columns
+	LatticeW    int     `json:",omitempty"`
+	Diffusion   float64 // Diffusion coefficient of morphogens (per step)
+	MorphDecay  float64 // Rate of relaxation of morphogens to their production (per step)
+	MorphSource float64 `json:",omitempty"` // Production of morphogen 0 by the cells at x = 0
+	Pattern     string  `json:",omitempty"` // Spatial pattern of selected traits: "", stripes, gradient
+
+	// Developmental engine (see ode.go): map, rk4, or dopri; since format version 8.
+	DevEngine string
+	DevDt     float64 // (Initial) time step of the integrators
+	DevTol    float64 // Error tolerance of dopri
+
+	// Intrinsic noise of development (see noise.go); since format version 9.
+	DevNoise   string  // none, additive, or multiplicative
+	DevNoiseSD float64 // Strength (per unit time)
+
+	// Environments of development (see envs.go); since format version 10.
+	NEnvs  int    // Number of environments (bodies) of each individual: Anc, Nov, ...
+	FitAgg string // Fitness over the environments: nov, mean, geomean, or min
+
+	// One genome for all bodies (see shared.go); since format version 11.
+	SharedGenome bool `json:",omitempty"`
+
+	// Fitness function (see fitness.go); since format version 12.
+	Fitness FitnessSpec
+
+	// Selection (see selection.go); since format version 13.
+	Selection  string  // wagner, sus, tournament, truncation, or moran
+	Tournament int     // Size of tournaments
+	TruncFrac  float64 // Fraction of the population selected by truncation
+	Elite      int     // Number of the fittest copied unchanged to the next generation
+
+	// Recombination (see recombination.go); since format version 14.
+	Recombination string    // free, clonal, kpoint, linkage, or uniform
+	CrossRate     float64   // Probability that a mating recombines
+	CrossPoints   int       // Number of crossover points (kpoint)
+	RecombRate    float64   // Probability of crossover between adjacent genes (linkage)
+	LinkageMap    []float64 `json:",omitempty"` // Per pair of adjacent genes (linkage); empty: RecombRate
+
+	// Layers of development (see layers.go); empty: the EFGHJP model above.
+	Layers []LayerSpec `json:",omitempty"`
 }
 
-func CurrentSettings() Settings {
-	return Settings{MaxPop: maxPop, MaxDevStep: maxDevStep,
-		NGenes: ngenes, NEnv: nenv, NSel: nsel, NCells: ncells,
-		WithCue: with_cue, FLayer: withF, HLayer: withH, JLayer: withJ,
-		Pfback: pheno_feedback, SDNoise: devNoise, MutRate: mutRate,
-		TauF: tauF, TauG: tauG, TauH: tauH,
-		DensityE: DensityE, DensityF: DensityF, DensityG: DensityG,
-		DensityH: DensityH, DensityJ: DensityJ, DensityP: DensityP}
+//Remark: defaults to full model!
+func DefaultSettings() Settings {
+	return Settings{MaxPop: 200, MaxDevStep: 200,
+		NGenes: 200, NEnv: 200, NSel: 40, NCells: 1,
+		WithCue: true, FLayer: true, HLayer: true, JLayer: false,
+		Pfback: false, SDNoise: 0.05, MutRate: 0.005,
+		TauF: 0.2, TauG: 1.0, TauH: 1.0,
+		DensityE: defaultDensity, DensityF: defaultDensity, DensityG: defaultDensity,
+		DensityH: defaultDensity, DensityJ: defaultDensity, DensityP: defaultDensity,
+		CueMag: 1.0, EpsDev: 1.0e-5, CCStep: 5.0,
+		BaseSelStrength: 20.0, SelDevStep: 20.0, MinWagnerFitness: 0.01,
+		ActF: "lecunatan", ActG: "lecunatan", ActH: "lecunatan", ActP: "tanh",
+		WeightModel: "ternary", WeightSD: 1.0, PerturbRate: 0.0, PerturbStep: 0.1,
+		DensityS: defaultDensity, Diffusion: 0.1, MorphDecay: 0.1,
+		DevEngine: "map", DevDt: 0.2, DevTol: 1.0e-8,
+		DevNoise: "none", DevNoiseSD: 0.0,
+		NEnvs: NBodies, FitAgg: "nov",
+		Fitness:   FitnessSpec{Func: "gaussL1", DevCost: "exp"},
+		Selection: "wagner", Tournament: 2, TruncFrac: 0.5, Elite: 0,
+		Recombination: "free", CrossRate: 1.0, CrossPoints: 1, RecombRate: 0.5}
 
 }
 
 const (
-	cueMag = 1.0 // each trait is +/-cueMag
 	//maxDevStep = 200    // Maximum steps for development.
-	epsDev   = 1.0e-5 // Convergence criterion of development.
-	eps      = 1.0e-50
-	sqrt3    = 1.73205080756887729352744634150587236694280525381038062805580697
-	ccStep   = 5.0                  // Number of steady steps for convergence
-	alphaEMA = 2.0 / (1.0 + ccStep) // exponential moving average/variance
+	eps   = 1.0e-50
+	sqrt3 = 1.73205080756887729352744634150587236694280525381038062805580697
 )
 
-// Length of a gene for Unicellular organism.
-var fullGeneLength = 4*ngenes + 2*nenv
+// Model holds the parameters of a run and the quantities derived from them.
+/*
+   Settings are the raw input (as saved with populations); the densities of
+   absent layers are zeroed and the slopes of activation functions are
+   computed when the Model is built. Functions that depend on the model take
+   it explicitly, so that populations with different Settings can coexist.
+*/
+type Model struct {
+	Settings
+	withE bool // = WithCue || Pfback
 
-//calculated from layers present or absent.
+	// Length of a gene for Unicellular organism.
+	//calculated from layers present or absent.
+	fullGeneLength int
 
-const baseSelStrength float64 = 20.0 // default selection strength; to be normalized by number of cells
-const selDevStep float64 = 20.0      // Developmental steps for selection
+	layers []devLayer // Layers in the order of development (see layers.go)
+	signal int        // Index of the signal layer; -1: no signaling
+	nsig   int        // Number of units of the signal layer sent
+	nbrs   [][]int    // Neighbours of cells on the lattice
 
-const minWagnerFitness float64 = 0.01
+	// Per matrix (order of genomeMats)
+	matDensity  []float64 // Density in mutations; 0 for unused matrices
+	initDensity []float64 // Density in randomization
+	activeMats  []int     // Matrices used in development
 
-// slope of activation functions
-var omega_f float64 = 1.0
-var omega_g float64 = 1.0
-var omega_h float64 = 1.0
-var omega_p float64 = 1.0
+	alphaEMA float64 // exponential moving average/variance; = 2/(1+CCStep)
+	decayEMA float64 // = 1 - alphaEMA (computed as (CCStep-1)/(CCStep+1) to match the former constant)
 
-//Remark: defaults to full model!
+	perMatrixMut bool // false: MutRate over the whole gene (as before per-matrix rates)
+	mutRates     []float64
+	frozen       []bool
+
+	fitFunc    FitnessFunc // Fitness.Func
+	selector   Selector    // Selection
+	recombiner Recombiner  // Recombination
+
+	run *runState // Random number generators of the run (see rng.go)
+}
 
 type Vec = []float64 //Vector is a slice
 type Dmat = []Vec
 type Tensor3 []Dmat
 
-func SetSeed(seed int64) {
-	rand.Seed(seed)
-}
-
-func SetParams(s Settings) {
-	maxPop = s.MaxPop
-	maxDevStep = s.MaxDevStep
-	ngenes = s.NGenes
-	nenv = s.NEnv
-	nsel = s.NSel
-	ncells = s.NCells
-	with_cue = s.WithCue
-	withF = s.FLayer
-	withH = s.HLayer
-	withJ = s.JLayer
-	pheno_feedback = s.Pfback
-	devNoise = s.SDNoise
-	mutRate = s.MutRate
-	withE = with_cue || pheno_feedback
-	tauF = s.TauF
-	tauG = s.TauG
-	tauH = s.TauH
-	DensityE = s.DensityE
-	DensityF = s.DensityF
-	DensityG = s.DensityG
-	DensityH = s.DensityH
-	DensityJ = s.DensityJ
-	DensityP = s.DensityP
-
-	from_g := DensityG * float64(ngenes)
-
-	if withE {
-		from_e := DensityE * float64(nenv)
-		if with_cue && pheno_feedback {
-			omega_f = 1.0 / math.Sqrt(2*from_e+from_g*(2-tauG))
-		} else {
-			omega_f = 1.0 / math.Sqrt(from_e+from_g*(2-tauG))
-		}
-	} else {
-		omega_f = 1.0 / math.Sqrt(from_g*(2-tauG))
-		DensityE = 0.0
+// Model of Settings s; invalid Settings are fatal (see NewModelChecked).
+func NewModel(s Settings) *Model {
+	m, err := NewModelChecked(s)
+	if err != nil {
+		log.Fatal(err)
 	}
+	return m
+}
 
-	if withF {
-		omega_g = 1.0 / math.Sqrt(DensityF*float64(ngenes)*(2-tauF))
-	} else {
-		DensityF = 0.0
-		efac := 1.0
-		if with_cue && pheno_feedback {
-			efac = 2.0
-		}
-		omega_g = 1.0 / math.Sqrt(DensityG*float64(ngenes)*(2-tauG)+efac*DensityE*float64(nenv))
+// Model of Settings s, or the error of s.Check.
+func NewModelChecked(s Settings) (*Model, error) {
+	if err := s.Check(); err != nil {
+		return nil, err
 	}
+	m := &Model{Settings: s, run: newRunState()}
+	m.withE = s.WithCue || s.Pfback
+	m.fullGeneLength = 4*s.NGenes + 2*s.NEnv
+	m.alphaEMA = 2.0 / (1.0 + s.CCStep)
+	m.decayEMA = (s.CCStep - 1.0) / (s.CCStep + 1.0)
 
-	if withH {
-		if withJ {
-			omega_h = 1.0 / math.Sqrt(from_g*((2-tauG)+(2-tauH)))
-		} else {
-			omega_h = 1.0 / math.Sqrt(from_g*(2-tauG))
-			DensityJ = 0.0
+	if len(s.Layers) == 0 { // Densities of absent layers (EFGHJP model)
+		if !m.withE {
+			m.DensityE = 0.0
+		}
+		if !s.FLayer {
+			m.DensityF = 0.0
+		}
+		if !s.HLayer {
+			m.DensityH = 0.0
+		}
+		if s.HLayer && !s.JLayer { // J is kept without H as before.
+			m.DensityJ = 0.0
 		}
-		omega_p = 1.0 / math.Sqrt(DensityP*float64(ngenes)*(2-tauH))
-	} else {
-		omega_h = 0.0
-		DensityH = 0.0
-		omega_p = 1.0 / math.Sqrt(DensityP*float64(ngenes)*(2-tauG))
-	}
-	/* // Trying not calling EMA for NoDev instead.
-	if maxDevStep == 1 {
-		omega_p *= 10.0 //Arbitrary factor to increase sensitivity of NoDev.
 	}
-	*/
-
-}
-
-func GetMaxPop() int {
-	return maxPop
-}
 
-func GetNcells() int {
-	return ncells
-}
-
-func GetNenv() int {
-	return nenv
-}
+	m.setLayers()
+	m.setLattice()
+	m.setMutRates()
+	m.fitFunc = fitnessFuncs[s.Fitness.Func]
+	m.selector = selectors[s.Selection]
+	m.recombiner = recombiners[s.Recombination]
 
-func GetNsel() int {
-	return nsel
+	return m, nil
 }
 
 func sigmoid(x, omega float64) float64 {
@@ -229,24 +259,87 @@ func relu(x, omega float64) float64 {
 	}
 }
 
-func sigmaf(x float64) float64 { //Activation function for epigenetic markers
-	return lecunatan(x * omega_f)
-	//return tanh(x, omega_f)
+// Activation functions selectable in Settings (ActF, ActG, ActH, ActP).
+/*
+   fn takes the input already multiplied by the omega of the layer. slope is
+   the derivative at 0; the omega of a layer is multiplied by
+   slope(default)/slope(chosen), so that all activations respond alike to
+   small inputs (the defaults are unchanged).
+*/
+type activation struct {
+	fn    func(float64) float64
+	slope float64
 }
 
-func sigmag(x float64) float64 { //Activation function for gene expression levels
-	return lecunatan(x * omega_g)
-	//return tanh(x, omega_g)
+var activations = map[string]activation{
+	"sigmoid":   {func(x float64) float64 { return sigmoid(x, 1) }, 0.25},
+	"tanh":      {func(x float64) float64 { return tanh(x, 1) }, 1},
+	"relu":      {func(x float64) float64 { return relu(x, 1) }, 1},
+	"arctan":    {func(x float64) float64 { return arctan(x, 1) }, 1},
+	"lecuntanh": {lecuntanh, 1.7159 * 2 / 3},
+	"lecunatan": {lecunatan, 6 / (math.Pi * sqrt3)},
 }
 
-func sigmah(x float64) float64 { //Activation function for higher order complexes
-	return lecunatan(x * omega_h) //abstract level of amount of higher order complexes
-	//return tanh(x, omega_h)
+// Names of the activation functions, sorted.
+func ActivationNames() []string {
+	names := make([]string, 0, len(activations))
+	for name := range activations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func rho(x float64) float64 { //Function for converting gene expression into phenotype
-	//return cueMag * lecunatan(x*omega_p)
-	return cueMag * tanh(x, omega_p)
+func activationGain(name, ref string) float64 {
+	if name == ref {
+		return 1
+	}
+	return activations[ref].slope / activations[name].slope
+}
+
+// Check reports invalid choices of functions and models in the Settings.
+func (s *Settings) Check() error {
+	if err := s.checkActivations(); err != nil {
+		return err
+	}
+	if err := s.checkWeights(); err != nil {
+		return err
+	}
+	if err := s.checkLayers(); err != nil {
+		return err
+	}
+	if err := s.checkMutRates(); err != nil {
+		return err
+	}
+	if err := s.checkLattice(); err != nil {
+		return err
+	}
+	if err := s.checkDevEngine(); err != nil {
+		return err
+	}
+	if err := s.checkDevNoise(); err != nil {
+		return err
+	}
+	if err := s.checkEnvs(); err != nil {
+		return err
+	}
+	if err := s.checkFitness(); err != nil {
+		return err
+	}
+	if err := s.checkSelection(); err != nil {
+		return err
+	}
+	return s.checkRecombination()
+}
+
+func (s *Settings) checkActivations() error {
+	for _, a := range []struct{ layer, name string }{{"f", s.ActF}, {"g", s.ActG}, {"h", s.ActH}, {"p", s.ActP}} {
+		if _, ok := activations[a.name]; !ok {
+			return fmt.Errorf("activation of layer %s: unknown function %q (known: %s)",
+				a.layer, a.name, strings.Join(ActivationNames(), ", "))
+		}
+	}
+	return nil
 }
 
 func NewDmat(nrow, ncol int) Dmat {
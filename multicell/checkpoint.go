@@ -0,0 +1,92 @@
+package multicell
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+)
+
+// Checkpoint of a training run.
+/*
+   Random numbers for development, mating and selection are drawn from
+   streams derived from the run seed (see rng.go), so the seed is all that
+   is needed to continue them. The environmental cue generator of the Model
+   is sequential, and its state is saved as the number of draws since
+   seeding.
+*/
+type Checkpoint struct {
+	Pop        Population // Population at the start of the next epoch
+	Epoch      int        // Last completed epoch
+	NEpoch     int        // Total number of epochs of the run
+	NGen       int        // Number of generations per epoch
+	Denv       int        // Magnitude of environmental change
+	Test       bool       // Test mode (export population every generation)
+	TrajFile   string     // Trajectory file
+	TrajOffset int64      // Size of trajectory file at the end of Epoch
+	JSONOut    string     // Output population file (or basename in test mode)
+	Archive    string     // Archive of populations in test mode
+	Keyframe   int        // Interval of full records in Archive
+	Seed       int64      // Run seed
+	RandCue    RandState  // State of the cue generator
+	EnvTraj    []Cues     // Trajectory of environmental cues
+	NovVec     []bool     // Novelty of environmental cues
+}
+
+// Fills in the states of the random number generators of the run of m.
+func (ck *Checkpoint) SetRandStates(m *Model) {
+	ck.Seed = m.Seed()
+	ck.RandCue = m.CueRandState()
+}
+
+// Restores the states of the random number generators of the run of m.
+func (ck *Checkpoint) RestoreRandStates(m *Model) {
+	m.SetSeed(ck.Seed)
+	m.SetCueRandState(ck.RandCue)
+}
+
+// Load reads a gzipped JSON encoding of checkpoint from r.
+func (ck *Checkpoint) Load(r io.Reader) error {
+	var ck1 Checkpoint
+	err := loadGzJSON(r, &ck1)
+	if err != nil {
+		return err
+	}
+	err = ck1.Pop.checkVersion()
+	if err != nil {
+		return err
+	}
+	ck1.Pop.upgrade()
+	err = ck1.Pop.Validate()
+	if err != nil {
+		return err
+	}
+	*ck = ck1
+	return nil
+}
+
+// Save writes a gzipped JSON encoding of checkpoint to w.
+func (ck *Checkpoint) Save(w io.Writer) error {
+	ck1 := *ck
+	ck1.Pop = *ck.Pop.stored()
+	return saveGzJSON(w, &ck1, gzip.BestSpeed)
+}
+
+func (ck *Checkpoint) ExportCheckpoint(filename string) error { //Exports checkpoint to .json.gz file
+	err := saveFile(filename, ck.Save)
+	if err != nil {
+		return err
+	}
+	log.Println("Successfully exported checkpoint to", filename)
+	return nil
+}
+
+func ImportCheckpoint(filename string) (Checkpoint, error) {
+	var ck Checkpoint
+	err := loadFile(filename, ck.Load)
+	if err != nil {
+		return ck, err
+	}
+	log.Println("Successfully imported checkpoint from", filename)
+
+	return ck, nil
+}
@@ -0,0 +1,137 @@
+package multicell
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Evolves pop over epochs epoch0 ... epoch1 as train does, writing the
+// trajectory to ftraj; returns the population at the start of the next
+// epoch.
+func evolveEpochs(t *testing.T, m *Model, pop Population, epoch0, epoch1 int, ftraj *os.File) Population {
+	t.Helper()
+	for epoch := epoch0; epoch <= epoch1; epoch++ {
+		pop1, err := pop.Evolve(m, 2, epoch, TrajWriter{W: ftraj})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pop = pop1
+		pop.ChangeEnvs(m, 5)
+	}
+	return pop
+}
+
+// Model and initial population of a run with seeds 7 and 11.
+func startRun() (*Model, Population) {
+	s := DefaultSettings()
+	s.MaxPop = 10
+	s.NGenes = 50
+	s.NEnv = 50
+	s.NSel = 10
+	m := NewModel(s)
+	m.SetSeed(7)
+	m.SetSeedCue(11)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+	return m, pop
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	create := func(name string) *os.File {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+
+	m, pop := startRun()
+	want := evolveEpochs(t, m, pop, 1, 3, create("direct.dat"))
+
+	m, pop = startRun()
+	ftraj := create("resumed.dat")
+	pop = evolveEpochs(t, m, pop, 1, 1, ftraj)
+	ck := Checkpoint{Pop: pop, Epoch: 1, NEpoch: 3}
+	ck.SetRandStates(m)
+	ckname := filepath.Join(dir, "run.ck.json.gz")
+	if err := ck.ExportCheckpoint(ckname); err != nil {
+		t.Fatal(err)
+	}
+
+	ck1, err := ImportCheckpoint(ckname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := NewModel(ck1.Pop.Params)
+	ck1.RestoreRandStates(m1)
+	got := evolveEpochs(t, m1, ck1.Pop, ck1.Epoch+1, ck1.NEpoch, ftraj)
+
+	direct, err := os.ReadFile(filepath.Join(dir, "direct.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := os.ReadFile(filepath.Join(dir, "resumed.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resumed) != string(direct) {
+		t.Errorf("trajectories differ:\nresumed %s\ndirect  %s", resumed, direct)
+	}
+	if !reflect.DeepEqual(got.NovEnvs, want.NovEnvs) {
+		t.Error("environments differ")
+	}
+	for i := range got.Indivs {
+		if !reflect.DeepEqual(got.Indivs[i].Bodies[INovEnv].Genome, want.Indivs[i].Bodies[INovEnv].Genome) {
+			t.Fatalf("genome of individual %d differs", i)
+		}
+	}
+}
+
+func TestCheckpointLoad(t *testing.T) {
+	m, pop := startRun()
+	saved := Checkpoint{Pop: pop, Epoch: 2, NEpoch: 3}
+	saved.SetRandStates(m)
+	var data bytes.Buffer
+	if err := saved.Save(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := func() Checkpoint {
+		return Checkpoint{TrajFile: "stale.dat", Archive: "stale.evar", Keyframe: 4,
+			NovVec: []bool{true}, Pop: Population{Envs: []Cues{NewCues(1, 1)}}}
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want func() Checkpoint // after Load
+		fail bool
+	}{
+		{"stale fields cleared", data.Bytes(), func() Checkpoint { return saved }, false},
+		{"corrupt file", []byte("not gzip"), stale, true},
+		{"truncated file", data.Bytes()[:data.Len()/2], stale, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ck := stale()
+			err := ck.Load(bytes.NewReader(tt.data))
+			if (err != nil) != tt.fail {
+				t.Fatalf("error %v", err)
+			}
+			want := tt.want()
+			if ck.TrajFile != want.TrajFile || ck.Archive != want.Archive || ck.Keyframe != want.Keyframe ||
+				!reflect.DeepEqual(ck.NovVec, want.NovVec) || len(ck.Pop.Envs) != len(want.Pop.Envs) {
+				t.Errorf("got %+v %v, want %+v %v", ck.TrajFile, ck.NovVec, want.TrajFile, want.NovVec)
+			}
+			if !tt.fail && (ck.Epoch != saved.Epoch || ck.Seed != saved.Seed || ck.RandCue != saved.RandCue) {
+				t.Errorf("epoch %d, seed %d, cue %v; want %d, %d, %v",
+					ck.Epoch, ck.Seed, ck.RandCue, saved.Epoch, saved.Seed, saved.RandCue)
+			}
+		})
+	}
+}
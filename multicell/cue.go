@@ -6,23 +6,69 @@ import (
 	"math/rand"
 )
 
-var rand_cue = rand.New(rand.NewSource(99))
+// Source of the generator of environmental cues of a run (Model.run.cue).
+// It counts the number of draws so that its state can be saved in
+// checkpoints and restored by replaying the draws.
+type cueSource struct {
+	src   rand.Source64
+	seed  int64
+	ndraw uint64
+}
+
+type RandState struct {
+	Seed  int64
+	NDraw uint64 // Number of draws since seeding
+}
+
+func newCueSource(seed int64) *cueSource {
+	src := &cueSource{src: rand.NewSource(seed).(rand.Source64)}
+	src.Seed(seed)
+	return src
+}
+
+func (src *cueSource) Seed(seed int64) {
+	src.src.Seed(seed)
+	src.seed = seed
+	src.ndraw = 0
+}
+
+func (src *cueSource) Int63() int64 {
+	src.ndraw++
+	return src.src.Int63()
+}
+
+func (src *cueSource) Uint64() uint64 {
+	src.ndraw++
+	return src.src.Uint64()
+}
+
+func (m *Model) CueRandState() RandState {
+	src := m.run.cueSrc
+	return RandState{src.seed, src.ndraw}
+}
+
+func (m *Model) SetCueRandState(state RandState) {
+	m.run.cue.Seed(state.Seed)
+	for i := uint64(0); i < state.NDraw; i++ {
+		m.run.cueSrc.Uint64()
+	}
+}
 
 type Cue = Vec //Environment cue is a special kind of vector
 
 type Cues = []Cue //Cue array object
 
-func SetSeedCue(seed int64) {
-	rand_cue.Seed(seed)
+func (m *Model) SetSeedCue(seed int64) {
+	m.run.cue.Seed(seed)
 }
 
-func RandomEnv(density float64) Cue { //Fake up a boolean environment vector for celltype id
-	v := NewVec(nenv)
+func RandomEnv(m *Model, density float64) Cue { //Fake up a boolean environment vector for celltype id
+	v := NewVec(m.NEnv)
 	for i := range v {
-		if rand_cue.Float64() < density {
-			v[i] = cueMag
+		if m.run.cue.Float64() < density {
+			v[i] = m.CueMag
 		} else {
-			v[i] = -cueMag
+			v[i] = -m.CueMag
 		}
 	}
 	return v
@@ -37,10 +83,13 @@ func NewCues(ncells, nenv int) Cues {
 }
 
 //Randomly generate cue array
-func RandomEnvs(ncells int, density float64) Cues {
-	vs := make([]Cue, ncells)
+func RandomEnvs(m *Model, density float64) Cues {
+	if m.Pattern != "" {
+		return PatternEnvs(m)
+	}
+	vs := make([]Cue, m.NCells)
 	for id := range vs {
-		vs[id] = RandomEnv(density)
+		vs[id] = RandomEnv(m, density)
 	}
 	return vs
 }
@@ -54,21 +103,21 @@ func CopyCues(cues Cues) Cues {
 	return vs
 }
 
-func AddNoise2CueNormal(cue_out, cue Cue, eta float64) {
+func AddNoise2CueNormal(cue_out, cue Cue, eta float64, rng *rand.Rand) {
 	copy(cue_out, cue)
 	for i, t := range cue {
-		// Don't use rand_cue here. Use the system rand instead.
-		cue_out[i] = t + eta*rand.NormFloat64()
+		// Don't use the cue generator of the run here. Use the stream of the individual instead.
+		cue_out[i] = t + eta*rng.NormFloat64()
 	}
 
 	return
 }
 
-func AddNoise2CueFlip(cue_out, cue Cue, eta float64) {
+func AddNoise2CueFlip(cue_out, cue Cue, eta float64, rng *rand.Rand) {
 	copy(cue_out, cue)
 	for i, t := range cue {
-		// Don't use rand_cue here. Use the system rand instead.
-		if rand.Float64() < eta {
+		// Don't use the cue generator of the run here. Use the stream of the individual instead.
+		if rng.Float64() < eta {
 			cue_out[i] = -t
 		}
 	}
@@ -76,7 +125,7 @@ func AddNoise2CueFlip(cue_out, cue Cue, eta float64) {
 }
 
 // Mutate precisely n bits of environment cue; ignore id part
-func ChangeEnv(cue Cue, n int) Cue {
+func ChangeEnv(m *Model, cue Cue, n int) Cue {
 	env1 := CopyVec(cue)
 	if n == 0 {
 		return env1
@@ -85,7 +134,7 @@ func ChangeEnv(cue Cue, n int) Cue {
 	for i := range indices {
 		indices[i] = i
 	}
-	rand_cue.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
+	m.run.cue.Shuffle(len(indices), func(i, j int) { indices[i], indices[j] = indices[j], indices[i] })
 	for _, i := range indices[0:n] {
 		env1[i] = -env1[i]
 	}
@@ -94,21 +143,23 @@ func ChangeEnv(cue Cue, n int) Cue {
 }
 
 // make sure to change env(1:nsel) if n < nsel/2
-func ChangeEnv2(cue Cue, n int) Cue {
+func ChangeEnv2(m *Model, cue Cue, n int) Cue {
 	if n == 0 {
 		return CopyVec(cue)
 	}
 
+	nsel := m.NSel
+	nenv := m.NEnv
 	env1 := CopyVec(cue[0:nsel])
 	env2 := CopyVec(cue[nsel:])
 	if n <= nsel/2 {
-		env1 = ChangeEnv(env1, n)
+		env1 = ChangeEnv(m, env1, n)
 	} else if n-nsel/2 <= nenv-nsel {
-		env1 = ChangeEnv(env1, nsel/2)
-		env2 = ChangeEnv(env2, n-nsel/2)
+		env1 = ChangeEnv(m, env1, nsel/2)
+		env2 = ChangeEnv(m, env2, n-nsel/2)
 	} else {
-		env2 = ChangeEnv(env2, nenv-nsel)
-		env1 = ChangeEnv(env1, n-(nenv-nsel))
+		env2 = ChangeEnv(m, env2, nenv-nsel)
+		env1 = ChangeEnv(m, env1, n-(nenv-nsel))
 	}
 	if false {
 		d1 := DistVecs1(env1, cue[0:nsel]) / 2
@@ -120,17 +171,28 @@ func ChangeEnv2(cue Cue, n int) Cue {
 	return env1
 }
 
-func ChangeEnvs(cues Cues, n int) Cues { //Flips precisely n bits in each environment cue
+func ChangeEnvs(m *Model, cues Cues, n int) Cues { //Flips precisely n bits in each environment cue
 	cues1 := CopyCues(cues)
+	if m.Pattern != "" { // Same bits in all cells to keep the pattern
+		env0 := ChangeEnv2(m, cues[0], n)
+		for j, v := range env0 {
+			if v != cues[0][j] {
+				for i := range cues1 {
+					cues1[i][j] = -cues[i][j]
+				}
+			}
+		}
+		return cues1
+	}
 	for i, cue := range cues {
-		cues1[i] = ChangeEnv2(cue, n)
+		cues1[i] = ChangeEnv2(m, cue, n)
 	}
 	return cues1
 }
 
 func GetCueVar(cues Cues) float64 { //Sum of elementwise variance in environment cue
 	mu := GetMeanVec(cues)
-	v := NewVec(nenv + ncells)
+	v := NewVec(len(mu))
 	sigma2 := 0.0
 	for _, c := range cues {
 		DiffVecs(v, c, mu)
@@ -142,11 +204,11 @@ func GetCueVar(cues Cues) float64 { //Sum of elementwise variance in environment
 	return sigma2
 }
 
-func GetSelEnvs(cues Cues) Cues {
+func GetSelEnvs(m *Model, cues Cues) Cues {
 	cues1 := make([]Cue, len(cues))
 	for i, cue := range cues {
-		cues1[i] = NewVec(nsel)
-		copy(cues1[i], cue[0:nsel])
+		cues1[i] = NewVec(m.NSel)
+		copy(cues1[i], cue[0:m.NSel])
 	}
 
 	return cues1
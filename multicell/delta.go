@@ -0,0 +1,202 @@
+package multicell
+
+import (
+	"fmt"
+)
+
+// Delta encoding of generations in population archives.
+/*
+   Offspring genomes are made from the Nov genomes of their parents by
+   recombination (mostly by rows, see recombination.go) and a few point
+   mutations (Mutate). A delta record stores, for each body of each
+   individual, which parent each row of the genome is taken from (row mask)
+   and the elements that differ from it (edits), so elements mixed by
+   uniform recombination or by columns (P) are edits. The parents are the
+   individuals of the preceding record in the archive.
+
+   Cell states and fitness are not stored; they are recomputed by developing
+   the individuals again with the random number streams of the run (Seed).
+   This reproduces them exactly with the same program on the same platform.
+   Genomes are always reproduced exactly.
+*/
+
+type genomeEdit struct {
+	Mat      uint8 // Index in genomeMats
+	Row, Col int32
+	Val      float64 // 0 removes the element
+}
+
+type deltaGenome struct {
+	Mask  []uint64 // Bit set: row is taken from mom rather than dad
+	Edits []genomeEdit
+}
+
+type deltaIndiv struct {
+	Id, DadId, MomId int
+	Bodies           []deltaGenome
+}
+
+type deltaRecord struct {
+	FormatVersion    int
+	Params           Settings
+	Epoch, Gen       int
+	Seed             int64 // Run seed for development
+	NovEnvs, AncEnvs Cues
+	Envs             []Cues `json:",omitempty"`
+	Indivs           []deltaIndiv
+	Provenance       *Provenance
+}
+
+func genomeMats(G *Genome) []*Spmat {
+	mats := []*Spmat{&G.E, &G.F, &G.G, &G.H, &G.J, &G.P}
+	for i := range G.Extra {
+		mats = append(mats, &G.Extra[i])
+	}
+	return mats
+}
+
+// Number of elements in which row i of mat differs from row i of base.
+func rowDiff(mat, base *Spmat, i int) int {
+	n := 0
+	c1, v1 := mat.Row(i)
+	c2, v2 := base.Row(i)
+	mergeRows(c1, v1, c2, v2, func(j int, a, b float64) {
+		if a != b {
+			n++
+		}
+	})
+	return n
+}
+
+func encodeGenome(G, dad, mom *Genome) deltaGenome {
+	var dg deltaGenome
+	mats, dmats, mmats := genomeMats(G), genomeMats(dad), genomeMats(mom)
+	k := 0 // Row index over all matrices
+	for im, mat := range mats {
+		for i := 0; i < mat.NRow(); i++ {
+			if k/64 >= len(dg.Mask) {
+				dg.Mask = append(dg.Mask, 0)
+			}
+			base := dmats[im]
+			if mom := mmats[im]; rowDiff(mat, mom, i) < rowDiff(mat, base, i) {
+				dg.Mask[k/64] |= 1 << uint(k%64)
+				base = mom
+			}
+			c1, v1 := mat.Row(i)
+			c2, v2 := base.Row(i)
+			mergeRows(c1, v1, c2, v2, func(j int, a, b float64) {
+				if a != b {
+					dg.Edits = append(dg.Edits, genomeEdit{uint8(im), int32(i), int32(j), a})
+				}
+			})
+			k++
+		}
+	}
+	return dg
+}
+
+// Rebuilds genome G (of the right dimensions) from the parents' genomes.
+func (dg *deltaGenome) apply(G, dad, mom *Genome) error {
+	mats, dmats, mmats := genomeMats(G), genomeMats(dad), genomeMats(mom)
+	k := 0
+	for im, mat := range mats {
+		nrow := mat.NRow()
+		if dmats[im].NRow() != nrow || mmats[im].NRow() != nrow {
+			return &DimensionError{"parent genome rows", dmats[im].NRow(), nrow}
+		}
+		mat.RowPtr = mat.RowPtr[:1]
+		mat.Col = mat.Col[:0]
+		mat.Val = mat.Val[:0]
+		for i := 0; i < nrow; i++ {
+			base := dmats[im]
+			if k/64 < len(dg.Mask) && dg.Mask[k/64]&(1<<uint(k%64)) != 0 {
+				base = mmats[im]
+			}
+			mat.appendRow(base.Row(i))
+			k++
+		}
+	}
+	for _, e := range dg.Edits {
+		if int(e.Mat) >= len(mats) || e.Row < 0 || int(e.Row) >= mats[e.Mat].NRow() ||
+			e.Col < 0 || int(e.Col) >= mats[e.Mat].Ncol {
+			return fmt.Errorf("delta record: edit out of range (%d, %d, %d)", e.Mat, e.Row, e.Col)
+		}
+		mats[e.Mat].Set(int(e.Row), int(e.Col), e.Val)
+	}
+	return nil
+}
+
+// Nov genomes of individuals, indexed by Id; nil unless Ids are positions.
+func novGenomes(indivs []Indiv) []Genome {
+	genomes := make([]Genome, len(indivs))
+	for i, indiv := range indivs {
+		if indiv.Id != i {
+			return nil
+		}
+		genomes[i] = *indiv.Bodies[INovEnv].Genome
+	}
+	return genomes
+}
+
+func encodeDelta(pop *Population, parents []Genome, seed int64) deltaRecord {
+	d := deltaRecord{pop.FormatVersion, pop.Params, pop.Epoch, pop.Gen, seed,
+		pop.NovEnvs, pop.AncEnvs, pop.Envs, make([]deltaIndiv, len(pop.Indivs)), pop.Provenance}
+	for k, indiv := range pop.Indivs {
+		dad, mom := &parents[indiv.DadId], &parents[indiv.MomId]
+		nb := len(indiv.Bodies)
+		if pop.Params.SharedGenome {
+			nb = 1
+		}
+		di := deltaIndiv{indiv.Id, indiv.DadId, indiv.MomId, make([]deltaGenome, nb)}
+		for b := range di.Bodies {
+			di.Bodies[b] = encodeGenome(indiv.Bodies[b].Genome, dad, mom)
+		}
+		d.Indivs[k] = di
+	}
+	return d
+}
+
+// Rebuilds the individuals (genomes only) from the parents' genomes.
+func (d *deltaRecord) indivs(m *Model, parents []Genome) ([]Indiv, error) {
+	indivs := make([]Indiv, len(d.Indivs))
+	for k, di := range d.Indivs {
+		if di.DadId < 0 || di.DadId >= len(parents) || di.MomId < 0 || di.MomId >= len(parents) {
+			return nil, fmt.Errorf("delta record: parents (%d, %d) not in preceding generation", di.DadId, di.MomId)
+		}
+		indiv := NewIndiv(m, di.Id)
+		indiv.DadId = di.DadId
+		indiv.MomId = di.MomId
+		nb := len(indiv.Bodies)
+		if m.SharedGenome {
+			nb = 1
+		}
+		if err := checkDim("delta record Bodies", len(di.Bodies), nb); err != nil {
+			return nil, err
+		}
+		for b := range di.Bodies {
+			err := di.Bodies[b].apply(indiv.Bodies[b].Genome, &parents[di.DadId], &parents[di.MomId])
+			if err != nil {
+				return nil, err
+			}
+		}
+		indivs[k] = indiv
+	}
+	return indivs, nil
+}
+
+// Rebuilds the population from the parents' genomes and develops it.
+func (d *deltaRecord) population(parents []Genome) (Population, error) {
+	m, err := NewModelChecked(d.Params)
+	if err != nil {
+		return Population{}, fmt.Errorf("Settings: %w", err)
+	}
+	indivs, err := d.indivs(m, parents)
+	if err != nil {
+		return Population{}, err
+	}
+	pop := Population{d.FormatVersion, d.Params, d.Epoch, 0, d.NovEnvs, d.AncEnvs, d.Envs, indivs, d.Provenance}
+	pop.shareGenomes()
+	pop.devPop(m, d.Gen, d.Seed)
+
+	return pop, nil
+}
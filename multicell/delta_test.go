@@ -0,0 +1,214 @@
+package multicell
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func deltaModel(ngenes int) *Model {
+	s := DefaultSettings()
+	s.MaxPop = 8
+	s.NGenes = ngenes
+	s.NEnv = 16
+	s.NSel = 4
+	return NewModel(s)
+}
+
+func assertEqualGenomes(t *testing.T, what string, G0, G1 *Genome) {
+	t.Helper()
+	mats0, mats1 := genomeMats(G0), genomeMats(G1)
+	for i := range mats0 {
+		if err := mats0[i].check(); err != nil {
+			t.Fatalf("%s: matrix %d: %v", what, i, err)
+		}
+		if !reflect.DeepEqual(denseOf(mats0[i]), denseOf(mats1[i])) {
+			t.Fatalf("%s: matrix %d differs", what, i)
+		}
+	}
+}
+
+// Compares genomes by their elements, and the rest as is.
+func assertEqualPopulations(t *testing.T, what string, pop0, pop1 *Population) {
+	t.Helper()
+	if len(pop0.Indivs) != len(pop1.Indivs) {
+		t.Fatalf("%s: %d and %d individuals", what, len(pop0.Indivs), len(pop1.Indivs))
+	}
+	strip := func(pop *Population) Population {
+		pop1 := *pop
+		pop1.Indivs = make([]Indiv, len(pop.Indivs))
+		for k, indiv := range pop.Indivs {
+			indiv.Bodies = append([]Body(nil), indiv.Bodies...)
+			for b := range indiv.Bodies {
+				indiv.Bodies[b].Genome = nil
+			}
+			pop1.Indivs[k] = indiv
+		}
+		return pop1
+	}
+	if !reflect.DeepEqual(strip(pop0), strip(pop1)) {
+		t.Fatalf("%s: populations differ", what)
+	}
+	for k := range pop0.Indivs {
+		for b := range pop0.Indivs[k].Bodies {
+			assertEqualGenomes(t, fmt.Sprintf("%s: indiv %d, body %d", what, k, b),
+				pop0.Indivs[k].Bodies[b].Genome, pop1.Indivs[k].Bodies[b].Genome)
+		}
+	}
+}
+
+// Generations written with delta records read back as they were evolved.
+func TestDeltaArchive(t *testing.T) {
+	m := deltaModel(20)
+	m.SetSeed(3)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+	pop.Epoch = 1
+
+	filename := filepath.Join(t.TempDir(), "run.evar")
+	ar, err := CreateArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar.SetKeyframe(3)
+	var pops []Population
+	for gen := 1; gen <= 5; gen++ {
+		pop.DevPop(m, gen)
+		if err := ar.Append(&pop, m.Seed()); err != nil {
+			t.Fatal(err)
+		}
+		pops = append(pops, pop)
+		pop = pop.PairReproduce(m, m.MaxPop)
+	}
+	if err := ar.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := OpenArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	for _, want := range pops {
+		got, err := rd.Read(want.Epoch, want.Gen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqualPopulations(t, fmt.Sprint("gen ", want.Gen), &got, &want)
+	}
+}
+
+// Delta records with invalid Settings are errors of Read, not fatal.
+func TestDeltaInvalidSettings(t *testing.T) {
+	m := deltaModel(20)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+	pop.Epoch = 1
+
+	filename := filepath.Join(t.TempDir(), "run.evar")
+	ar, err := CreateArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar.SetKeyframe(3)
+	for gen := 1; gen <= 3; gen++ {
+		pop.DevPop(m, gen)
+		rec := pop
+		if gen > 1 {
+			rec.Params.Selection = "bogus"
+		}
+		if err := ar.Append(&rec, m.Seed()); err != nil {
+			t.Fatal(err)
+		}
+		pop = pop.PairReproduce(m, m.MaxPop)
+	}
+	if err := ar.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := OpenArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	for gen := 2; gen <= 3; gen++ { // Developed, and parents of the next
+		if _, err := rd.Read(1, gen); err == nil {
+			t.Errorf("gen %d: no error", gen)
+		}
+	}
+}
+
+func TestDeltaUniformEdits(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 8
+	s.NGenes = 12
+	s.NEnv = 16
+	s.NSel = 4
+	s.Recombination = "uniform"
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	dad, mom := *pop.Indivs[0].Bodies[INovEnv].Genome, *pop.Indivs[1].Bodies[INovEnv].Genome
+	G0, G1 := dad.Copy(), mom.Copy()
+	m.recombine(genomeMats(&G0), genomeMats(&G1), rand.New(rand.NewSource(5)))
+
+	tests := []struct {
+		name string
+		G    *Genome
+	}{
+		{"dad", &dad},
+		{"mom", &mom},
+		{"offspring 0", &G0},
+		{"offspring 1", &G1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg := encodeGenome(tt.G, &dad, &mom)
+			switch {
+			case tt.G == &dad || tt.G == &mom:
+				if len(dg.Edits) != 0 {
+					t.Errorf("%d edits for a parent genome", len(dg.Edits))
+				}
+			case len(dg.Edits) == 0:
+				t.Error("no edits for mixed rows")
+			}
+			G := NewGenome(m)
+			if err := dg.apply(&G, &dad, &mom); err != nil {
+				t.Fatal(err)
+			}
+			assertEqualGenomes(t, "genome", &G, tt.G)
+		})
+	}
+}
+
+func TestDeltaApplyErrors(t *testing.T) {
+	m := deltaModel(12)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	dad, mom := pop.Indivs[0].Bodies[0].Genome, pop.Indivs[1].Bodies[0].Genome
+	other := NewGenome(deltaModel(14))
+
+	tests := []struct {
+		name     string
+		dg       deltaGenome
+		dad, mom *Genome
+	}{
+		{"matrix out of range", deltaGenome{Edits: []genomeEdit{{Mat: 20}}}, dad, mom},
+		{"row out of range", deltaGenome{Edits: []genomeEdit{{Mat: 0, Row: 12}}}, dad, mom},
+		{"column out of range", deltaGenome{Edits: []genomeEdit{{Mat: 5, Col: 12}}}, dad, mom},
+		{"negative row", deltaGenome{Edits: []genomeEdit{{Mat: 0, Row: -1}}}, dad, mom},
+		{"parent of other dimensions", deltaGenome{}, dad, &other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			G := NewGenome(m)
+			if err := tt.dg.apply(&G, tt.dad, tt.mom); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+)
+
+// Development in several environments.
+/*
+   Each individual develops one body in each of NEnvs environments: body 0
+   in the ancestral environment (AncEnvs), body 1 in the novel one
+   (NovEnvs), and body k >= 2 in Population.Envs[k-2]. The further
+   environments are random at first and each is changed by denv traits at
+   every change of environments, like the novel one, so that they fluctuate
+   independently. As for Anc and Nov, the bodies have their own mutations.
+
+   The fitness of an individual aggregates the fitness of its bodies with
+   FitAgg: "nov" (fitness in the novel environment only, as before), "mean",
+   "geomean" (geometric mean), or "min" (worst case). Bodies that do not
+   converge have zero fitness.
+
+   With NEnvs > 2, Indiv.Dp[k][l] is ||p(e_k) - e_l||, the mismatch of the
+   phenotype of body k to environment l, generalizing Dp1e1, Dp0e0, Dp1e0,
+   and Dp0e1 (which are also kept); the trajectory gets the mean fitness in
+   each environment and the mean Dp of each pair.
+*/
+
+var FitAggs = []string{"nov", "mean", "geomean", "min"}
+
+func (s *Settings) checkEnvs() error {
+	ok := false
+	for _, a := range FitAggs {
+		ok = ok || s.FitAgg == a
+	}
+	switch {
+	case s.NEnvs < NBodies:
+		return fmt.Errorf("NEnvs must be at least %d (Anc and Nov)", NBodies)
+	case !ok:
+		return fmt.Errorf("unknown FitAgg %q (known: %v)", s.FitAgg, FitAggs)
+	}
+	return nil
+}
+
+// Environments of the bodies: Anc, Nov, and the further ones.
+func (pop *Population) envList() []Cues {
+	return append([]Cues{pop.AncEnvs, pop.NovEnvs}, pop.Envs...)
+}
+
+// Makes random further environments up to NEnvs.
+func (pop *Population) addEnvs(m *Model) {
+	for len(pop.Envs) < m.NEnvs-NBodies {
+		pop.Envs = append(pop.Envs, RandomEnvs(m, 0.5))
+	}
+}
+
+// Fitness of body k developed in env (see fitness.go).
+func (indiv *Indiv) getBodyFitness(m *Model, k int, env Cues) float64 {
+	ndevstep := indiv.getNDevStep(k)
+
+	if m.MaxDevStep > 1 && ndevstep == m.MaxDevStep {
+		return 0.0
+	}
+
+	body := &indiv.Bodies[k]
+	fdev := m.devCost(body)
+	ferr := m.fitFunc.Penalty(m, body, env)
+	return math.Exp(-(ferr + fdev))
+}
+
+// Fitness of the bodies developed in envs aggregated with FitAgg.
+func (indiv *Indiv) getFitness(m *Model, envs []Cues) float64 {
+	if m.FitAgg == "nov" {
+		return indiv.getBodyFitness(m, INovEnv, envs[INovEnv])
+	}
+	n := float64(len(indiv.Bodies))
+	agg := 0.0
+	if m.FitAgg == "min" {
+		agg = math.Inf(1)
+	}
+	for k := range indiv.Bodies {
+		f := indiv.getBodyFitness(m, k, envs[k])
+		switch m.FitAgg {
+		case "mean":
+			agg += f / n
+		case "geomean":
+			if f == 0 {
+				return 0.0
+			}
+			agg += math.Log(f) / n
+		case "min":
+			agg = math.Min(agg, f)
+		}
+	}
+	if m.FitAgg == "geomean" {
+		return math.Exp(agg)
+	}
+	return agg
+}
+
+// Mismatches of the phenotypes of all bodies to all environments.
+func getPEDiffs(m *Model, bodies []Body, envs []Cues) [][]float64 {
+	dp := make([][]float64, len(bodies))
+	for k := range bodies {
+		dp[k] = make([]float64, len(envs))
+		for l, env := range envs {
+			dp[k][l] = getPEDiff(m, bodies[k], env)
+		}
+	}
+	return dp
+}
+
+// Mean fitness in each environment and mean Dp of each pair (NEnvs > 2).
+func (pop *Population) getEnvStats(m *Model, stats *PopStats) {
+	if m.NEnvs <= NBodies {
+		return
+	}
+	fn := float64(len(pop.Indivs))
+	envs := pop.envList()
+	stats.BodyFit = make([]float64, m.NEnvs)
+	stats.PEDiff = make([][]float64, m.NEnvs)
+	for k := range stats.PEDiff {
+		stats.PEDiff[k] = make([]float64, m.NEnvs)
+	}
+	for _, indiv := range pop.Indivs {
+		for k := range indiv.Bodies {
+			stats.BodyFit[k] += indiv.getBodyFitness(m, k, envs[k]) / fn
+			for l, d := range indiv.Dp[k] {
+				stats.PEDiff[k][l] += d / fn
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package multicell
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitAgg(t *testing.T) {
+	e := math.Exp
+	type body struct {
+		perr     float64
+		ndevstep int
+	}
+	converged := []body{{0.5, 0}, {1, 0}, {2, 0}}
+	stuck := []body{{0.5, 0}, {1, 0}, {0, 10}} // body 2 did not converge
+	tests := []struct {
+		agg    string
+		bodies []body
+		want   float64
+	}{
+		{"nov", converged, e(-1)},
+		{"mean", converged, (e(-0.5) + e(-1) + e(-2)) / 3},
+		{"geomean", converged, e(-3.5 / 3)},
+		{"min", converged, e(-2)},
+		{"nov", stuck, e(-1)},
+		{"mean", stuck, (e(-0.5) + e(-1)) / 3},
+		{"geomean", stuck, 0},
+		{"min", stuck, 0},
+	}
+	for _, tt := range tests {
+		s := DefaultSettings()
+		s.NEnvs = 3
+		s.FitAgg = tt.agg
+		s.MaxDevStep = 10
+		s.BaseSelStrength = 1
+		s.SelDevStep = 1
+		m := NewModel(s)
+		indiv := NewIndiv(m, 0)
+		for k, b := range tt.bodies {
+			indiv.Bodies[k].PErr = b.perr
+			indiv.Bodies[k].NDevStep = b.ndevstep
+		}
+		if got := indiv.getFitness(m, make([]Cues, 3)); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s of %v: %v, want %v", tt.agg, tt.bodies, got, tt.want)
+		}
+	}
+}
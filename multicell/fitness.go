@@ -0,0 +1,137 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Fitness functions.
+/*
+   The fitness of a body that converged is
+     exp(-(penalty + cost of development))
+   and 0 if development did not converge. The FitnessFunc (Fitness.Func)
+   gives the penalty from the mismatch of the selected traits (0 ... NSel-1)
+   of the phenotype to the environment, d = (p - e) / CueMag:
+     gaussL1     BaseSelStrength * <w |d|>    (default, as before)
+     gaussL2     BaseSelStrength * <w d^2>
+     truncation  0 if <w |d|> <= Fitness.Threshold, otherwise infinite
+   where <w ...> is the mean over cells and traits weighted by the weight of
+   each trait, Fitness.Weights (NSel values; empty: all 1). The cost of
+   development (Fitness.DevCost) is NDevStep / SelDevStep ("exp", default)
+   or nothing ("none"). The specification is in Settings, so it is saved
+   with the population.
+*/
+
+type FitnessSpec struct {
+	Func      string    // gaussL1, gaussL2, or truncation
+	Weights   []float64 `json:",omitempty"` // Weights of the selected traits
+	Threshold float64   `json:",omitempty"` // Maximum error of truncation
+	DevCost   string    // exp or none
+}
+
+type FitnessFunc interface {
+	// Penalty of the phenotype of body developed in envs.
+	Penalty(m *Model, body *Body, envs Cues) float64
+}
+
+type gaussFitness struct {
+	l2 bool
+}
+
+func (f gaussFitness) Penalty(m *Model, body *Body, envs Cues) float64 {
+	return m.phenoErr(body, envs, f.l2) * m.BaseSelStrength
+}
+
+type truncFitness struct{}
+
+func (truncFitness) Penalty(m *Model, body *Body, envs Cues) float64 {
+	if m.phenoErr(body, envs, false) <= m.Fitness.Threshold {
+		return 0
+	}
+	return math.Inf(1)
+}
+
+var fitnessFuncs = map[string]FitnessFunc{
+	"gaussL1":    gaussFitness{false},
+	"gaussL2":    gaussFitness{true},
+	"truncation": truncFitness{},
+}
+
+var DevCosts = []string{"exp", "none"}
+
+func FitnessNames() []string {
+	names := make([]string, 0, len(fitnessFuncs))
+	for name := range fitnessFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Settings) checkFitness() error {
+	f := s.Fitness
+	if _, ok := fitnessFuncs[f.Func]; !ok {
+		return fmt.Errorf("unknown fitness function %q (known: %v)", f.Func, FitnessNames())
+	}
+	ok := false
+	for _, c := range DevCosts {
+		ok = ok || f.DevCost == c
+	}
+	if !ok {
+		return fmt.Errorf("unknown DevCost %q (known: %v)", f.DevCost, DevCosts)
+	}
+	if f.Threshold < 0 {
+		return fmt.Errorf("fitness Threshold must not be negative")
+	}
+	if len(f.Weights) == 0 {
+		return nil
+	}
+	if len(f.Weights) != s.NSel {
+		return fmt.Errorf("fitness Weights: %d values for NSel = %d traits", len(f.Weights), s.NSel)
+	}
+	sum := 0.0
+	for _, w := range f.Weights {
+		if w < 0 {
+			return fmt.Errorf("fitness Weights must not be negative")
+		}
+		sum += w
+	}
+	if sum == 0 {
+		return fmt.Errorf("fitness Weights are all zero")
+	}
+	return nil
+}
+
+// Weighted mean error of the selected traits of body in envs; L1 or L2.
+func (m *Model) phenoErr(body *Body, envs Cues, l2 bool) float64 {
+	w := m.Fitness.Weights
+	if !l2 && len(w) == 0 {
+		return body.PErr
+	}
+	err, sumw := 0.0, 0.0
+	for i, cell := range body.Cells {
+		for j := 0; j < m.NSel; j++ {
+			wj := 1.0
+			if len(w) > 0 {
+				wj = w[j]
+			}
+			d := (cell.P[j] - envs[i][j]) / m.CueMag
+			if l2 {
+				err += wj * d * d
+			} else {
+				err += wj * math.Abs(d)
+			}
+			sumw += wj
+		}
+	}
+	return err / sumw
+}
+
+// Cost of development of body.
+func (m *Model) devCost(body *Body) float64 {
+	if m.Fitness.DevCost == "none" {
+		return 0
+	}
+	return float64(body.NDevStep) / m.SelDevStep
+}
@@ -0,0 +1,72 @@
+package multicell
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFitnessFuncs(t *testing.T) {
+	// d = p - e = (0, -2) in the selected traits; the others do not count.
+	env := Cues{{1, 1, 1, 1}}
+	p := Vec{1, -1, -1, -1}
+	tests := []struct {
+		name    string
+		fitness FitnessSpec
+		want    float64 // exp(-(penalty + cost))
+	}{
+		{"gaussL1", FitnessSpec{Func: "gaussL1", DevCost: "none"}, math.Exp(-1.5 * 1)},
+		{"gaussL2", FitnessSpec{Func: "gaussL2", DevCost: "none"}, math.Exp(-1.5 * 2)},
+		{"gaussL1 weights", FitnessSpec{Func: "gaussL1", Weights: []float64{3, 1}, DevCost: "none"}, math.Exp(-1.5 * 0.5)},
+		{"gaussL2 weights", FitnessSpec{Func: "gaussL2", Weights: []float64{3, 1}, DevCost: "none"}, math.Exp(-1.5 * 1)},
+		{"gaussL1 cost", FitnessSpec{Func: "gaussL1", DevCost: "exp"}, math.Exp(-(1.5 + 2))},
+		{"truncation at threshold", FitnessSpec{Func: "truncation", Threshold: 1, DevCost: "none"}, 1},
+		{"truncation below threshold", FitnessSpec{Func: "truncation", Threshold: 0.9, DevCost: "none"}, 0},
+		{"truncation cost", FitnessSpec{Func: "truncation", Threshold: 1, DevCost: "exp"}, math.Exp(-2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.NEnv = 4
+			s.NSel = 2
+			s.BaseSelStrength = 1.5
+			s.SelDevStep = 2
+			s.Fitness = tt.fitness
+			if err := s.checkFitness(); err != nil {
+				t.Fatal(err)
+			}
+			m := NewModel(s)
+			indiv := NewIndiv(m, 0)
+			body := &indiv.Bodies[INovEnv]
+			body.Cells[0].P = p
+			body.PErr = 1 // L1 error without weights, as from development
+			body.NDevStep = 4
+			if got := indiv.getBodyFitness(m, INovEnv, env); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("fitness %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckFitness(t *testing.T) {
+	tests := []struct {
+		name    string
+		fitness FitnessSpec
+	}{
+		{"unknown function", FitnessSpec{Func: "gauss", DevCost: "exp"}},
+		{"unknown cost", FitnessSpec{Func: "gaussL1", DevCost: "linear"}},
+		{"negative threshold", FitnessSpec{Func: "truncation", Threshold: -1, DevCost: "exp"}},
+		{"weights for too few traits", FitnessSpec{Func: "gaussL1", Weights: []float64{1}, DevCost: "exp"}},
+		{"negative weight", FitnessSpec{Func: "gaussL1", Weights: []float64{1, -1}, DevCost: "exp"}},
+		{"zero weights", FitnessSpec{Func: "gaussL1", Weights: []float64{0, 0}, DevCost: "exp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.NSel = 2
+			s.Fitness = tt.fitness
+			if err := s.checkFitness(); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
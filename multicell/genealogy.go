@@ -11,7 +11,7 @@ func DOT_Genealogy(genfilename, popfilename string, ngen, npop int) {
 	var id, dadid, momid string
 	nanctraj := []int{}
 	rnanctraj := []int{}
-	pop := NewPopulation(CurrentSettings())
+	pop := NewPopulation(NewModel(DefaultSettings()))
 	genfile := fmt.Sprintf("%s.dot", genfilename)
 
 	fdot, err := os.OpenFile(genfile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
@@ -22,7 +22,10 @@ func DOT_Genealogy(genfilename, popfilename string, ngen, npop int) {
 	pars := make(map[int]bool)
 	for gen := ngen; gen > 0; gen-- {
 		jfilename := fmt.Sprintf("%s_%3.3d.json", popfilename, gen)
-		pop.ImportPopGz(jfilename)
+		err = pop.ImportPopGz(jfilename)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		ids := make([]string, 0)
 		for _, indiv := range pop.Indivs {
@@ -2,8 +2,10 @@ package multicell
 
 import (
 	//	"log"
+	"fmt"
 	"math"
 	"math/rand"
+	"strings"
 
 	"gonum.org/v1/gonum/stat/distuv"
 )
@@ -15,247 +17,226 @@ type Genome struct { //Genome of an individual
 	H Spmat //Contribution of gene expression on higher order complexes
 	J Spmat //Interaction between higher order complexes
 	P Spmat //Resulting expressed phenotype
+
+	Extra []Spmat `json:",omitempty"` // Other matrices of Settings.Layers
 }
 
-func NewGenome() Genome { //Generate new genome matrix ensemble
-	E := NewSpmat(ngenes, nenv)
-	F := NewSpmat(ngenes, ngenes)
-	G := NewSpmat(ngenes, ngenes)
-	H := NewSpmat(ngenes, ngenes)
-	J := NewSpmat(ngenes, ngenes)
-	P := NewSpmat(nenv, ngenes)
-	genome := Genome{E, F, G, H, J, P}
+func NewGenome(m *Model) Genome { //Generate new genome matrix ensemble
+	var genome Genome
+	mats := genomeMats(&genome)
+	for i, d := range m.matrixDims() {
+		if i < nGenomeMats {
+			*mats[i] = NewSpmat(d.nrow, d.ncol)
+		} else {
+			genome.Extra = append(genome.Extra, NewSpmat(d.nrow, d.ncol))
+		}
+	}
 
 	return genome
 }
 
-func (G *Genome) Randomize() {
-	G.E.Randomize(DensityE)
-	G.F.Randomize(DensityF)
-	G.G.Randomize(DensityF)
-	G.H.Randomize(DensityH)
-	G.J.Randomize(DensityJ)
-	G.P.Randomize(DensityP)
+func (G *Genome) Randomize(m *Model, rng *rand.Rand) {
+	for i, mat := range genomeMats(G) {
+		mat.Randomize(m.initDensity[i], m.weightMag, rng)
+	}
 }
 
 func (G *Genome) Clear() { //Sets all entries of genome to zero
-	for _, r := range G.E.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
-	}
-	for _, r := range G.F.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
-	}
-	for _, r := range G.G.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
-	}
-	for _, r := range G.H.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
-	}
-	for _, r := range G.J.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
-	}
-	for _, r := range G.P.Mat {
-		for j := range r { //range over keys
-			delete(r, j)
-		}
+	for _, mat := range genomeMats(G) {
+		mat.Clear()
 	}
 }
 
 func (parent *Genome) Copy() Genome { //creates copy of genome
-	e := parent.E.Copy()
-	f := parent.F.Copy()
-	g := parent.G.Copy()
-	hg := parent.H.Copy()
-	hh := parent.J.Copy()
-	p := parent.P.Copy()
-
-	genome := Genome{e, f, g, hg, hh, p}
+	genome := Genome{E: parent.E.Copy(), F: parent.F.Copy(), G: parent.G.Copy(),
+		H: parent.H.Copy(), J: parent.J.Copy(), P: parent.P.Copy()}
+	if parent.Extra != nil {
+		genome.Extra = make([]Spmat, len(parent.Extra))
+		for i := range parent.Extra {
+			genome.Extra[i] = parent.Extra[i].Copy()
+		}
+	}
 
 	return genome
 }
 
-func DiffGenomes(Gout, G1, G0 *Genome) { //Elementwise difference between two genomes
-	if withE {
-		Gout.E = DiffSpmat(&G1.E, &G0.E)
-	}
-	if withF {
-		Gout.F = DiffSpmat(&G1.F, &G0.F)
-	}
-
-	Gout.G = DiffSpmat(&G1.G, &G0.G)
-
-	if withH {
-		Gout.H = DiffSpmat(&G1.H, &G0.H)
-		if withJ {
-			Gout.J = DiffSpmat(&G1.J, &G0.J)
-		}
+func DiffGenomes(m *Model, Gout, G1, G0 *Genome) { //Elementwise difference between two genomes
+	mats, mats1, mats0 := genomeMats(Gout), genomeMats(G1), genomeMats(G0)
+	for _, i := range m.activeMats {
+		*mats[i] = DiffSpmat(mats1[i], mats0[i])
 	}
-
-	Gout.P = DiffSpmat(&G1.P, &G0.P)
 }
 
-func (G *Genome) NormalizeGenome() Genome {
+func (G *Genome) NormalizeGenome(m *Model) Genome {
 	lambda2 := 0.0
 	eG := G.Copy()
 
-	if withE {
-		for _, m := range G.E.Mat {
-			for _, v := range m {
-				lambda2 += v * v
-			}
+	mats := genomeMats(G)
+	for _, i := range m.activeMats {
+		for _, v := range mats[i].Val {
+			lambda2 += v * v
 		}
 	}
 
-	if withF {
-		for _, m := range G.F.Mat {
-			for _, v := range m {
-				lambda2 += v * v
-			}
-		}
+	if lambda2 == 0 {
+		return eG //avoid division by zero
 	}
 
-	for _, m := range G.G.Mat {
-		for _, v := range m {
-			lambda2 += v * v
-		}
+	lambda := math.Sqrt(lambda2)
+	sca := 1.0 / lambda
+	emats := genomeMats(&eG)
+	for _, i := range m.activeMats {
+		emats[i].Scale(sca)
 	}
 
-	if withH {
-		for _, m := range G.H.Mat {
-			for _, v := range m {
-				lambda2 += v * v
-			}
-		}
+	return eG
+}
 
-		if withJ {
-			for _, m := range G.J.Mat {
-				for _, v := range m {
-					lambda2 += v * v
-				}
-			}
-		}
-	}
+func (genome *Genome) FlatVec(m *Model) Vec {
+	vec := make([]float64, 0)
 
-	for _, m := range G.P.Mat {
-		for _, v := range m {
-			lambda2 += v * v
+	mats := genomeMats(genome)
+	for _, k := range m.activeMats {
+		for i := 0; i < mats[k].NRow(); i++ {
+			vec = mats[k].appendDenseRow(vec, i)
 		}
 	}
 
-	if lambda2 == 0 {
-		return eG //avoid division by zero
-	}
+	return vec
+}
 
-	lambda := math.Sqrt(lambda2)
-	sca := 1.0 / lambda
-	if withE {
-		eG.E.Scale(sca)
-	}
+const genomeLetters = "EFGHJP" // Letters of the matrices in the order of genomeMats
 
-	if withF {
-		eG.F.Scale(sca)
-	}
+const nGenomeMats = len(genomeLetters)
 
-	eG.G.Scale(sca)
-	if withH {
-		eG.H.Scale(sca)
-		if withJ {
-			eG.J.Scale(sca)
+// Matrices other than E ... P (Settings.Layers) mutate with MutRate unless
+// given in MutRates.
+func (m *Model) setMutRates() {
+	s := m.Settings
+	n := len(m.matDensity)
+	m.mutRates = make([]float64, n)
+	m.frozen = make([]bool, n)
+	m.perMatrixMut = len(s.Layers) > 0
+	for i := range m.mutRates {
+		m.mutRates[i] = s.MutRate
+	}
+	rates := []float64{s.MutRateE, s.MutRateF, s.MutRateG, s.MutRateH, s.MutRateJ, s.MutRateP}
+	for i, r := range rates {
+		m.perMatrixMut = m.perMatrixMut || r > 0
+		if r > 0 {
+			m.mutRates[i] = r
 		}
 	}
+	index := s.matrixIndices()
+	for name, r := range s.MutRates {
+		m.mutRates[index[name]] = r
+		m.perMatrixMut = true
+	}
+	for _, name := range s.frozenNames() {
+		m.frozen[index[name]] = true
+		m.perMatrixMut = true
+	}
+}
 
-	eG.P.Scale(sca)
-
-	return eG
+// Indices of the matrices in genomeMats by name.
+func (s *Settings) matrixIndices() map[string]int {
+	index := make(map[string]int)
+	for i, d := range s.matrixDims() {
+		index[d.name] = i
+	}
+	return index
 }
 
-func (genome *Genome) FlatVec() Vec {
-	vec := make([]float64, 0)
+// Names of the Frozen matrices: comma-separated names, or letters of EFGHJP
+// (as before) unless Frozen is the name of a matrix.
+func (s *Settings) frozenNames() []string {
+	if s.Frozen == "" {
+		return nil
+	}
+	if _, ok := s.matrixIndices()[s.Frozen]; ok || strings.Contains(s.Frozen, ",") {
+		return strings.Split(s.Frozen, ",")
+	}
+	return strings.Split(s.Frozen, "")
+}
 
-	if withE {
-		for _, v := range genome.E.Mat {
-			for j := 0; j < nenv; j++ {
-				vec = append(vec, v[j])
-			}
+func (s *Settings) checkMutRates() error {
+	for _, r := range []float64{s.MutRateE, s.MutRateF, s.MutRateG, s.MutRateH, s.MutRateJ, s.MutRateP} {
+		if r < 0 {
+			return fmt.Errorf("mutation rates must not be negative")
 		}
 	}
-
-	if withF {
-		for _, v := range genome.F.Mat {
-			for j := 0; j < ngenes; j++ {
-				vec = append(vec, v[j])
-			}
+	index := s.matrixIndices()
+	for name, r := range s.MutRates {
+		if _, ok := index[name]; !ok {
+			return fmt.Errorf("MutRates: unknown matrix %q", name)
+		}
+		if r < 0 {
+			return fmt.Errorf("mutation rates must not be negative")
 		}
 	}
-
-	for _, v := range genome.G.Mat {
-		for j := 0; j < ngenes; j++ {
-			vec = append(vec, v[j])
+	for _, name := range s.frozenNames() {
+		if _, ok := index[name]; !ok {
+			return fmt.Errorf("Frozen %q: unknown matrix %q", s.Frozen, name)
 		}
 	}
+	return nil
+}
+
+func (genome *Genome) Mutate(m *Model, rng *rand.Rand) {
+	if m.perMatrixMut {
+		genome.mutatePerMatrix(m, rng)
+	} else {
+		genome.mutateGene(m, rng)
+	}
 
-	if withH {
-		for _, v := range genome.H.Mat {
-			for j := 0; j < ngenes; j++ {
-				vec = append(vec, v[j])
-			}
-		}
-		if withJ {
-			for _, v := range genome.J.Mat {
-				for j := 0; j < ngenes; j++ {
-					vec = append(vec, v[j])
-				}
+	if m.PerturbRate > 0 { // Absent layers have no elements.
+		for i, mat := range genomeMats(genome) {
+			if !m.frozen[i] {
+				mat.perturbSpmat(m, m.PerturbRate, rng)
 			}
 		}
 	}
-	for _, v := range genome.P.Mat {
-		for j := 0; j < ngenes; j++ {
-			vec = append(vec, v[j])
+	return
+}
+
+// One Poisson number of mutations for each matrix.
+func (genome *Genome) mutatePerMatrix(m *Model, rng *rand.Rand) {
+	for i, mat := range genomeMats(genome) {
+		if !m.frozen[i] {
+			mat.mutateSpmat(m.matDensity[i], m.mutRates[i], m.weightMag, rng)
 		}
 	}
-
-	return vec
 }
 
-func (genome *Genome) Mutate() {
+// MutRate over the concatenated E, F, G, H, J, and P of a gene.
+func (genome *Genome) mutateGene(m *Model, rng *rand.Rand) {
 
-	tE := nenv
-	tF := tE + ngenes
-	tG := tF + ngenes
-	tH := tG + ngenes
-	tJ := tH + ngenes
+	tE := m.NEnv
+	tF := tE + m.NGenes
+	tG := tF + m.NGenes
+	tH := tG + m.NGenes
+	tJ := tH + m.NGenes
 
-	lambda := mutRate * float64(ngenes*fullGeneLength)
-	dist := distuv.Poisson{Lambda: lambda}
+	lambda := m.MutRate * float64(m.NGenes*m.fullGeneLength)
+	dist := distuv.Poisson{Lambda: lambda, Src: distSource(rng)}
 	nmut := int(dist.Rand())
 
 	for n := 0; n < nmut; n++ {
-		irow := rand.Intn(ngenes)
-		icol := rand.Intn(fullGeneLength)
+		irow := rng.Intn(m.NGenes)
+		icol := rng.Intn(m.fullGeneLength)
 
 		if icol < tE {
-			genome.E.pMutateSpmat(DensityE, irow, icol)
+			genome.E.pMutateSpmat(m.DensityE, irow, icol, m.weightMag, rng)
 		} else if icol < tF {
-			genome.F.pMutateSpmat(DensityF, irow, icol-tE)
+			genome.F.pMutateSpmat(m.DensityF, irow, icol-tE, m.weightMag, rng)
 		} else if icol < tG {
-			genome.G.pMutateSpmat(DensityG, irow, icol-tF)
+			genome.G.pMutateSpmat(m.DensityG, irow, icol-tF, m.weightMag, rng)
 		} else if icol < tH {
-			genome.H.pMutateSpmat(DensityH, irow, icol-tG)
+			genome.H.pMutateSpmat(m.DensityH, irow, icol-tG, m.weightMag, rng)
 		} else if icol < tJ {
-			genome.J.pMutateSpmat(DensityJ, irow, icol-tH)
+			genome.J.pMutateSpmat(m.DensityJ, irow, icol-tH, m.weightMag, rng)
 		} else {
-			genome.P.pMutateSpmat(DensityP, icol-tJ, irow)
+			genome.P.pMutateSpmat(m.DensityP, icol-tJ, irow, m.weightMag, rng)
 		}
 	}
-	return
 }
@@ -4,8 +4,7 @@ import (
 	//	"errors"
 	//"fmt"
 	"log"
-	"math"
-	//	"math/rand"
+	"math/rand"
 )
 
 type Cell struct { //A 'cell' is characterized by its gene expression and phenotype
@@ -18,11 +17,12 @@ type Cell struct { //A 'cell' is characterized by its gene expression and phenot
 	PErr     float64 // ||e - p||_1
 	NDevStep int     // Developmental path length
 
+	X map[string]Vec `json:",omitempty"` // Layers other than f, g, h, and p
 }
 
 type Body struct { //Do we want to reimplement this?
-	Genome   Genome
-	Cells    []Cell // Array of cells of different types
+	Genome   *Genome `json:",omitempty"` // Shared by all bodies with SharedGenome (see shared.go)
+	Cells    []Cell  // Array of cells of different types
 	PErr     float64
 	NDevStep int
 }
@@ -30,7 +30,7 @@ type Body struct { //Do we want to reimplement this?
 const (
 	IAncEnv = iota // Previous env
 	INovEnv        // Current env
-	NBodies
+	NBodies        // Minimum number of bodies (see NEnvs)
 )
 
 const ( // Index for cell state vectors
@@ -45,7 +45,7 @@ type Indiv struct { //An individual as an unicellular organism
 	Id         int
 	DadId      int
 	MomId      int
-	Bodies     []Body  //IAncEnv, INovEnv (see above const.)
+	Bodies     []Body  //IAncEnv, INovEnv (see above const.), and further environments (see envs.go)
 	Fit        float64 //Fitness with cues
 	WagFit     float64 //Wagner relative fitness
 	Plasticity float64 //Observed Plasticity
@@ -53,30 +53,52 @@ type Indiv struct { //An individual as an unicellular organism
 	Dp0e0      float64 // ||p(e0) - e0||
 	Dp1e0      float64 // ||p(e1) - e0||
 	Dp0e1      float64 // ||p(e0) - e1||
+
+	Dp [][]float64 `json:",omitempty"` // ||p(ek) - el|| with NEnvs > 2
 }
 
-func NewCell(id int) Cell { //Creates a new cell given id of cell.
-	e := NewVec(nenv)
-	f := NewVec(ngenes)
-	g := NewVec(ngenes)
-	h := NewVec(ngenes)
-	p := NewVec(nenv)
-	pv := NewVec(nenv)
-	cell := Cell{id, e, f, g, h, p, pv, 0.0, 0}
+func NewCell(m *Model, id int) Cell { //Creates a new cell given id of cell.
+	e := NewVec(m.NEnv)
+	f := NewVec(m.NGenes)
+	g := NewVec(m.NGenes)
+	h := NewVec(m.NGenes)
+	p := NewVec(m.NEnv)
+	pv := NewVec(m.NEnv)
+	cell := Cell{id, e, f, g, h, p, pv, 0.0, 0, nil}
+	for _, l := range m.layers {
+		if !isCellLayer(l.name) {
+			if cell.X == nil {
+				cell.X = make(map[string]Vec)
+			}
+			cell.X[l.name] = NewVec(l.size)
+		}
+	}
+	if m.Lattice > 0 { // Morphogens
+		if cell.X == nil {
+			cell.X = make(map[string]Vec)
+		}
+		cell.X["s"] = NewVec(m.nsig)
+	}
 
 	return cell
 }
 
 func (cell *Cell) Copy() Cell {
-	cell1 := NewCell(cell.Id)
-	copy(cell1.E, cell.E)
-	copy(cell1.F, cell.F)
-	copy(cell1.G, cell.G)
-	copy(cell1.H, cell.H)
-	copy(cell1.P, cell.P)
-	copy(cell1.Pvar, cell.Pvar)
+	cell1 := Cell{Id: cell.Id}
+	cell1.E = CopyVec(cell.E)
+	cell1.F = CopyVec(cell.F)
+	cell1.G = CopyVec(cell.G)
+	cell1.H = CopyVec(cell.H)
+	cell1.P = CopyVec(cell.P)
+	cell1.Pvar = CopyVec(cell.Pvar)
 	cell1.PErr = cell.PErr
 	cell1.NDevStep = cell.NDevStep
+	if cell.X != nil {
+		cell1.X = make(map[string]Vec, len(cell.X))
+		for name, v := range cell.X {
+			cell1.X[name] = CopyVec(v)
+		}
+	}
 
 	return cell1
 }
@@ -93,50 +115,76 @@ func (cell *Cell) GetState(ivec string, ibeg, iend int) Vec {
 		return cell.H[ibeg:iend]
 	case "P":
 		return cell.P[ibeg:iend]
-	default:
-		log.Fatal("Cell.GetState: Unknown state vector")
 	}
+	if x, ok := cell.X[ivec]; ok {
+		return x[ibeg:iend]
+	}
+	log.Fatal("Cell.GetState: Unknown state vector")
 
 	return nil // never happens
 }
 
-func NewBody(ncells int) Body {
-	genome := NewGenome()
-	cells := make([]Cell, ncells)
+// Layers stored in the fields of Cell rather than in X.
+func isCellLayer(name string) bool {
+	return name == "f" || name == "g" || name == "h" || name == "p"
+}
+
+// State vector of layer.
+func (cell *Cell) layerState(name string) Vec {
+	switch name {
+	case "f":
+		return cell.F
+	case "g":
+		return cell.G
+	case "h":
+		return cell.H
+	case "p":
+		return cell.P
+	}
+	return cell.X[name]
+}
+
+func NewBody(m *Model) Body {
+	genome := NewGenome(m)
+	cells := make([]Cell, m.NCells)
 	for id := range cells {
-		cells[id] = NewCell(id) //Initialize each cell
+		cells[id] = NewCell(m, id) //Initialize each cell
 	}
-	return Body{genome, cells, 0, 0}
+	return Body{&genome, cells, 0, 0}
 }
 
 func (body *Body) Copy() Body {
-	body1 := NewBody(ncells)
-	body1.PErr = body.PErr
-	body1.NDevStep = body.NDevStep
-	body1.Genome = body.Genome.Copy()
+	body1 := Body{PErr: body.PErr, NDevStep: body.NDevStep}
+	genome := body.Genome.Copy()
+	body1.Genome = &genome
+	body1.Cells = make([]Cell, len(body.Cells))
 	for i, cell := range body.Cells {
 		body1.Cells[i] = cell.Copy()
 	}
 	return body1
 }
 
-func NewIndiv(id int) Indiv { //Creates a new individual
-	bodies := make([]Body, NBodies)
+func NewIndiv(m *Model, id int) Indiv { //Creates a new individual
+	bodies := make([]Body, m.NEnvs)
 	for i := range bodies {
-		bodies[i] = NewBody(ncells)
+		bodies[i] = NewBody(m)
 	}
 
-	indiv := Indiv{id, 0, 0, bodies, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
+	indiv := Indiv{id, 0, 0, bodies, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, nil}
 
 	return indiv
 }
 
 func (indiv *Indiv) Copy() Indiv { //Deep copier
-	indiv1 := NewIndiv(indiv.Id)
+	indiv1 := Indiv{Id: indiv.Id}
 	indiv1.DadId = indiv.DadId
 	indiv1.MomId = indiv.MomId
+	indiv1.Bodies = make([]Body, len(indiv.Bodies))
 	for i, body := range indiv.Bodies {
 		indiv1.Bodies[i] = body.Copy()
+		if i > 0 && body.Genome == indiv.Bodies[0].Genome { // Shared genome
+			indiv1.Bodies[i].Genome = indiv1.Bodies[0].Genome
+		}
 	}
 	indiv1.Fit = indiv.Fit
 	indiv1.Plasticity = indiv.Plasticity
@@ -144,44 +192,57 @@ func (indiv *Indiv) Copy() Indiv { //Deep copier
 	indiv1.Dp0e0 = indiv.Dp0e0
 	indiv1.Dp1e0 = indiv.Dp1e0
 	indiv1.Dp0e1 = indiv.Dp0e1
+	if indiv.Dp != nil {
+		indiv1.Dp = make([][]float64, len(indiv.Dp))
+		for i, d := range indiv.Dp {
+			indiv1.Dp[i] = CopyVec(d)
+		}
+	}
 
 	return indiv1
 }
 
-func Mate(dad, mom *Indiv) (Indiv, Indiv) { //Generates offspring
-	bodies0 := make([]Body, NBodies)
+func Mate(m *Model, dad, mom *Indiv, rng *rand.Rand) (Indiv, Indiv) { //Generates offspring
+	bodies0 := make([]Body, m.NEnvs)
 	for i := range bodies0 {
-		bodies0[i] = NewBody(ncells)
+		bodies0[i] = NewBody(m)
 
 	}
 
-	bodies1 := make([]Body, NBodies)
+	bodies1 := make([]Body, m.NEnvs)
 	for i := range bodies1 {
-		bodies1[i] = NewBody(ncells)
+		bodies1[i] = NewBody(m)
 	}
 
 	genome0 := dad.Bodies[INovEnv].Genome.Copy()
 	genome1 := mom.Bodies[INovEnv].Genome.Copy()
-	CrossoverSpmats(genome0.E, genome1.E)
-	CrossoverSpmats(genome0.F, genome1.F)
-	CrossoverSpmats(genome0.G, genome1.G)
-	CrossoverSpmats(genome0.H, genome1.H)
-	CrossoverSpmats(genome0.J, genome1.J)
-	CrossoverSpmats(genome0.P, genome1.P)
-
-	bodies0[IAncEnv].Genome = genome0
-	bodies1[IAncEnv].Genome = genome1
-	bodies0[INovEnv].Genome = genome0.Copy()
-	bodies1[INovEnv].Genome = genome1.Copy()
-
-	// Different mutations for Anc and Nov envs.
-	bodies0[IAncEnv].Genome.Mutate()
-	bodies1[IAncEnv].Genome.Mutate()
-	bodies0[INovEnv].Genome.Mutate()
-	bodies1[INovEnv].Genome.Mutate()
-
-	kid0 := Indiv{dad.Id, dad.Id, mom.Id, bodies0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
-	kid1 := Indiv{mom.Id, dad.Id, mom.Id, bodies1, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0}
+	m.recombine(genomeMats(&genome0), genomeMats(&genome1), rng) // See recombination.go.
+
+	if m.SharedGenome { // One mutated genome for all envs (see shared.go).
+		genome0.Mutate(m, rng)
+		genome1.Mutate(m, rng)
+		for k := range bodies0 {
+			bodies0[k].Genome = &genome0
+			bodies1[k].Genome = &genome1
+		}
+	} else {
+		bodies0[IAncEnv].Genome = &genome0
+		bodies1[IAncEnv].Genome = &genome1
+		for k := INovEnv; k < m.NEnvs; k++ {
+			G0, G1 := genome0.Copy(), genome1.Copy()
+			bodies0[k].Genome = &G0
+			bodies1[k].Genome = &G1
+		}
+
+		// Different mutations for each env.
+		for k := range bodies0 {
+			bodies0[k].Genome.Mutate(m, rng)
+			bodies1[k].Genome.Mutate(m, rng)
+		}
+	}
+
+	kid0 := Indiv{dad.Id, dad.Id, mom.Id, bodies0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, nil}
+	kid1 := Indiv{mom.Id, dad.Id, mom.Id, bodies1, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, nil}
 
 	return kid0, kid1
 }
@@ -194,179 +255,313 @@ func (indiv *Indiv) getNDevStep(ienv int) int {
 	return indiv.Bodies[ienv].NDevStep
 }
 
-func (indiv *Indiv) getFitness() float64 { //fitness in novel/present environment
-	ndevstep := indiv.getNDevStep(INovEnv)
-
-	if maxDevStep > 1 && ndevstep == maxDevStep {
-		return 0.0
-	}
-
-	fdev := float64(ndevstep) / selDevStep
-	ferr := indiv.getPErr(INovEnv) * baseSelStrength
-	rawfit := math.Exp(-(ferr + fdev))
-	return rawfit
-}
-
-func getPlasticity(body0, body1 Body) float64 { //cue plasticity of individual
+func getPlasticity(m *Model, body0, body1 Body) float64 { //cue plasticity of individual
 	d2 := 0.0
 	for i, cell := range body0.Cells {
 		d2 += Dist2Vecs(cell.P, body1.Cells[i].P)
 	}
 
-	return d2 / float64(ncells*nenv)
+	return d2 / float64(m.NCells*m.NEnv)
 }
 
-func getPEDiff(body Body, envs Cues) float64 {
+func getPEDiff(m *Model, body Body, envs Cues) float64 {
+	nsel := m.NSel
 	diff := 0.0
 	for i, c := range body.Cells {
 		diff += DistVecs1(c.P[0:nsel], envs[i][0:nsel])
 	}
-	return diff / float64(ncells*nsel)
+	return diff / float64(m.NCells*nsel)
 }
 
-func (cell *Cell) updatePEMA(pnew Vec) {
+func (cell *Cell) updatePEMA(m *Model, pnew Vec) {
 	for i, pi := range pnew {
 		d := pi - cell.P[i]
-		incr := alphaEMA * d
+		incr := m.alphaEMA * d
 		cell.P[i] += incr
-		cell.Pvar[i] = (1 - alphaEMA) * (cell.Pvar[i] + d*incr)
+		cell.Pvar[i] = m.decayEMA * (cell.Pvar[i] + d*incr)
+	}
+}
+
+// Work vectors of development, reused across calls (one set per worker).
+type devBuffers struct {
+	zero  Vec
+	cells []cellBuffers // One per cell for synchronous development
+	sum   Vec           // Sum of the signals of all cells
+	ode   *odeBuffers   // Integrators (DevEngine other than map)
+}
+
+type cellBuffers struct {
+	e_p   Vec   // = env - p0
+	x0    []Vec // States of layers at the previous step
+	x1    []Vec // States of layers at the current step
+	mx    []Vec // Product of a matrix and an input
+	sig   Vec   // Signal received from the other cells (morphogens on a lattice)
+	morph Vec   // New concentrations of morphogens
+}
+
+func newDevBuffers(m *Model) *devBuffers {
+	buf := &devBuffers{zero: NewVec(m.NEnv)}
+	ncb := 1
+	if m.signal >= 0 {
+		ncb = m.NCells
+		buf.sum = NewVec(m.nsig)
+	}
+	if m.DevEngine != "map" {
+		buf.ode = newODEBuffers(m)
+	}
+	buf.cells = make([]cellBuffers, ncb)
+	for c := range buf.cells {
+		cb := &buf.cells[c]
+		cb.e_p = NewVec(m.NEnv)
+		for _, l := range m.layers {
+			cb.x0 = append(cb.x0, NewVec(l.size))
+			cb.x1 = append(cb.x1, NewVec(l.size))
+			cb.mx = append(cb.mx, NewVec(l.size))
+		}
+		if m.signal >= 0 {
+			cb.sig = NewVec(m.nsig)
+			cb.morph = NewVec(m.nsig)
+		}
 	}
+	return buf
 }
 
-func (cell *Cell) DevCell(G Genome, env Cue) Cell { //Develops a cell given cue
-	cell.P = Zeroes(nenv) // just to make sure it's zeroes.
-	cue := Zeroes(nenv)
-	g0 := Ones(ngenes)
-	f0 := Zeroes(ngenes)
-	h0 := Zeroes(ngenes)
+func (cell *Cell) DevCell(m *Model, G Genome, env Cue, rng *rand.Rand) Cell { //Develops a cell given cue
+	return cell.devCell(m, G, env, rng, newDevBuffers(m))
+}
 
-	e_p := NewVec(nenv) // = env - p0
+func (cell *Cell) devCell(m *Model, G Genome, env Cue, rng *rand.Rand, buf *devBuffers) Cell {
+	if buf.ode != nil {
+		return cell.devCellODE(m, G, env, rng, buf)
+	}
+	cb := &buf.cells[0]
+	cue := cell.startDev(m, env, rng, buf, cb)
+	mats := genomeMats(&G)
 
-	Ee := NewVec(ngenes)
-	Gg := NewVec(ngenes)
-	Hg := NewVec(ngenes)
-	Jh := NewVec(ngenes)
-	p1 := NewVec(nenv)
-	f1 := NewVec(ngenes)
-	g1 := NewVec(ngenes)
-	h1 := NewVec(ngenes)
+	for nstep := 1; nstep <= m.MaxDevStep; nstep++ {
+		cell.devStep(m, mats, cue, cb, rng)
+		if cell.endStep(m, nstep, cb) {
+			break
+		}
+	}
+	cell.finishDev(m, env, cb)
 
-	//  AddNoise2CueNormal(cell.E, env, devNoise)
-	AddNoise2CueFlip(cell.E, env, devNoise)
+	return *cell
+}
 
-	if with_cue {
-		cue = cell.E
+// Initial state of development; returns the cue seen by the cell.
+func (cell *Cell) startDev(m *Model, env Cue, rng *rand.Rand, buf *devBuffers, cb *cellBuffers) Vec {
+	for i := range cell.P {
+		cell.P[i] = 0 // just to make sure it's zeroes.
+	}
+	for k, l := range m.layers {
+		for i := range cb.x0[k] {
+			cb.x0[k][i] = l.init
+			cb.x1[k][i] = 0
+		}
+	}
+	for i := range cb.sig {
+		cb.sig[i] = 0
 	}
 
-	lambda := 1.0 / dampFactorE
+	//  AddNoise2CueNormal(cell.E, env, m.SDNoise, rng)
+	AddNoise2CueFlip(cell.E, env, m.SDNoise, rng)
 
-	for nstep := 1; nstep <= maxDevStep; nstep++ {
-		MultMatVec(Gg, G.G, g0)
-		if withE { //Model with or without cues
-			if pheno_feedback { //p-feedback is allowed
-				DiffVecs(e_p, cue, cell.P)
-				MultMatVec(Ee, G.E, e_p)
+	if m.WithCue {
+		return cell.E
+	}
+	return buf.zero
+}
+
+// One step of development: all layers in order.
+func (cell *Cell) devStep(m *Model, mats []*Spmat, cue Vec, cb *cellBuffers, rng *rand.Rand) {
+	x0, x1 := cb.x0, cb.x1
+	for k := range m.layers {
+		l := &m.layers[k]
+		x := x1[k]
+		for n, in := range l.inputs {
+			var src Vec
+			switch {
+			case in.src == srcCue:
+				src = cue
+			case in.src == srcCueMinus: //p-feedback
+				DiffVecs(cb.e_p, cue, cell.P)
+				src = cb.e_p
+			case in.src == srcSignal:
+				src = cb.sig
+			case in.prev:
+				src = x0[in.src]
+			default:
+				src = x1[in.src]
+			}
+			if in.mat >= 0 {
+				if n == 0 {
+					MultMatVec(x, *mats[in.mat], src)
+					continue
+				}
+				MultMatVec(cb.mx[k], *mats[in.mat], src)
+				src = cb.mx[k]
+			}
+			if n == 0 {
+				copy(x, src)
 			} else {
-				MultMatVec(Ee, G.E, cue)
+				AddVecs(x, x, src)
 			}
-			lambda *= dampFactorE
-			ScaleVec(Ee, lambda, Ee)
-			AddVecs(f1, Gg, Ee)
-		} else {
-			copy(f1, Gg)
 		}
-		if withF { //Allow or disallow epigenetic layer
-			applyFnVec(sigmaf, f1)
-			if tauF < 1 {
-				WAddVecs(f1, 1-tauF, f0, f1)
+		if l.act != nil {
+			for i, v := range x {
+				x[i] = l.scale * l.act(v*l.omega)
 			}
-			MultMatVec(g1, G.F, f1)
-		} else { //Remove epigenetic layer if false
-			copy(g1, f1)
 		}
-		applyFnVec(sigmag, g1)
-		if tauG < 1 {
-			WAddVecs(g1, 1-tauG, g0, g1)
+		if l.tau < 1 {
+			WAddVecs(x, 1-l.tau, x0[k], x)
 		}
-		if withH {
-			MultMatVec(Hg, G.H, g1)
-			if withJ {
-				MultMatVec(Jh, G.J, h0)
-				AddVecs(h1, Hg, Jh)
-			} else {
-				copy(h1, g1)
-			}
-			applyFnVec(sigmah, h1)
-			if tauH < 1 {
-				WAddVecs(h1, 1-tauH, h0, h1)
-			}
-		} else {
-			copy(h1, g1) //identity map
+		if l.noisy {
+			m.addDevNoise(x, 1, rng)
 		}
-		MultMatVec(p1, G.P, h1)
-		applyFnVec(rho, p1)
-
-		copy(f0, f1)
-		copy(g0, g1)
-		copy(h0, h1)
-		if maxDevStep == 1 {
-			copy(cell.P, p1) //Directly take phenotype if no developmental process.
-			break
-		} //else { // No need
+	}
 
-		cell.updatePEMA(p1)
+	for k := range m.layers {
+		copy(x0[k], x1[k])
+	}
+}
 
-		diff := 0.0
-		for _, v := range cell.Pvar {
-			diff += v
-		}
-		diff /= float64(len(cell.Pvar))
-		cell.NDevStep = nstep
-		if diff < epsDev {
-			break
+// Updates the phenotype after step nstep; returns true if converged.
+func (cell *Cell) endStep(m *Model, nstep int, cb *cellBuffers) bool {
+	p1 := cb.x1[len(m.layers)-1] // p is the last layer.
+	if m.MaxDevStep == 1 {
+		copy(cell.P, p1) //Directly take phenotype if no developmental process.
+		return true
+	} //else { // No need
+
+	cell.updatePEMA(m, p1)
+
+	diff := 0.0
+	for _, v := range cell.Pvar {
+		diff += v
+	}
+	diff /= float64(len(cell.Pvar))
+	cell.NDevStep = nstep
+	return diff < m.EpsDev
+}
+
+func (cell *Cell) finishDev(m *Model, env Cue, cb *cellBuffers) {
+	ip := len(m.layers) - 1
+	for k, l := range m.layers {
+		if k != ip {
+			copy(cell.layerState(l.name), cb.x1[k])
 		}
 	}
-	copy(cell.F, f1)
-	copy(cell.G, g1)
-	copy(cell.H, h1)
-	cell.PErr = DistVecs1(cell.P[0:nsel], env[0:nsel]) / cueMag
+	if m.Lattice > 0 {
+		copy(cell.X["s"], cb.sig)
+	}
+	cell.PErr = DistVecs1(cell.P[0:m.NSel], env[0:m.NSel]) / m.CueMag
+}
 
-	return *cell
+func (body *Body) DevBody(m *Model, envs Cues, rng *rand.Rand) Body {
+	return body.devBody(m, envs, rng, newDevBuffers(m))
 }
 
-func (body *Body) DevBody(envs Cues) Body {
+func (body *Body) devBody(m *Model, envs Cues, rng *rand.Rand, buf *devBuffers) Body {
+	if m.signal >= 0 {
+		body.devSignaling(m, envs, rng, buf)
+	} else {
+		for i := range body.Cells {
+			body.Cells[i].devCell(m, *body.Genome, envs[i], rng, buf)
+		}
+	}
+
 	sse := 0.0
 	maxdev := 0
 
-	for i, cell := range body.Cells {
-		body.Cells[i] = cell.DevCell(body.Genome, envs[i])
+	for _, cell := range body.Cells {
 		sse += cell.PErr
 		//fmt.Println("Ndev:",cell.NDevStep)
 		if cell.NDevStep > maxdev {
 			maxdev = cell.NDevStep
 		}
-		if cell.NDevStep > maxDevStep {
+		if cell.NDevStep > m.MaxDevStep {
 			log.Println("NDevStep greater than limit: ", cell.NDevStep)
 		}
 	}
 
-	body.PErr = sse / float64(ncells*nsel)
+	body.PErr = sse / float64(m.NCells*m.NSel)
 	body.NDevStep = maxdev
 
 	return *body
 }
 
-func (indiv *Indiv) Develop(ancenvs, novenvs Cues) Indiv { //Compare developmental process under different conditions
+// Synchronous development of cells exchanging signals.
+/*
+   At every step, each cell receives the mean state of the signal layer of
+   the other cells at the previous step (zero for a single cell), or the
+   morphogens on a lattice, so the order of cells does not matter.
+   Development stops when all the cells have converged.
+*/
+func (body *Body) devSignaling(m *Model, envs Cues, rng *rand.Rand, buf *devBuffers) {
+	ncells := len(body.Cells)
+	cues := make([]Vec, ncells)
+	for i := range body.Cells {
+		cues[i] = body.Cells[i].startDev(m, envs[i], rng, buf, &buf.cells[i])
+	}
+	mats := genomeMats(body.Genome)
+
+	for nstep := 1; nstep <= m.MaxDevStep; nstep++ {
+		if m.Lattice > 0 {
+			m.diffuseMorphogens(buf)
+		} else {
+			for i := range buf.sum {
+				buf.sum[i] = 0
+			}
+			for c := range body.Cells {
+				AddVecs(buf.sum, buf.sum, buf.cells[c].x0[m.signal][:m.nsig])
+			}
+			for c := range body.Cells {
+				cb := &buf.cells[c]
+				if ncells > 1 {
+					DiffVecs(cb.sig, buf.sum, cb.x0[m.signal][:m.nsig])
+					ScaleVec(cb.sig, 1/float64(ncells-1), cb.sig)
+				}
+			}
+		}
+
+		converged := true
+		for c := range body.Cells {
+			body.Cells[c].devStep(m, mats, cues[c], &buf.cells[c], rng)
+			if !body.Cells[c].endStep(m, nstep, &buf.cells[c]) {
+				converged = false
+			}
+		}
+		if converged {
+			break
+		}
+	}
+	for c := range body.Cells {
+		body.Cells[c].finishDev(m, envs[c], &buf.cells[c])
+	}
+}
+
+func (indiv *Indiv) Develop(m *Model, ancenvs, novenvs Cues, rng *rand.Rand, envs ...Cues) Indiv { //Compare developmental process under different conditions; envs: further environments (NEnvs > 2)
+	return indiv.develop(m, append([]Cues{ancenvs, novenvs}, envs...), rng, newDevBuffers(m))
+}
+
+// Develops body k in envs[k] (see envs.go).
+func (indiv *Indiv) develop(m *Model, envs []Cues, rng *rand.Rand, buf *devBuffers) Indiv {
 	//fmt.Printf("Id:%d",indiv.Id)
-	indiv.Bodies[IAncEnv].DevBody(ancenvs)
-	indiv.Bodies[INovEnv].DevBody(novenvs)
+	for k := range indiv.Bodies {
+		indiv.Bodies[k].devBody(m, envs[k], rng, buf)
+	}
+	ancenvs, novenvs := envs[IAncEnv], envs[INovEnv]
 
-	indiv.Fit = indiv.getFitness()
+	indiv.Fit = indiv.getFitness(m, envs)
 
-	indiv.Plasticity = getPlasticity(indiv.Bodies[IAncEnv], indiv.Bodies[INovEnv])
-	indiv.Dp1e1 = getPEDiff(indiv.Bodies[INovEnv], novenvs)
-	indiv.Dp0e0 = getPEDiff(indiv.Bodies[IAncEnv], ancenvs)
-	indiv.Dp1e0 = getPEDiff(indiv.Bodies[INovEnv], ancenvs)
-	indiv.Dp0e1 = getPEDiff(indiv.Bodies[IAncEnv], novenvs)
+	indiv.Plasticity = getPlasticity(m, indiv.Bodies[IAncEnv], indiv.Bodies[INovEnv])
+	indiv.Dp1e1 = getPEDiff(m, indiv.Bodies[INovEnv], novenvs)
+	indiv.Dp0e0 = getPEDiff(m, indiv.Bodies[IAncEnv], ancenvs)
+	indiv.Dp1e0 = getPEDiff(m, indiv.Bodies[INovEnv], ancenvs)
+	indiv.Dp0e1 = getPEDiff(m, indiv.Bodies[IAncEnv], novenvs)
+	if m.NEnvs > NBodies {
+		indiv.Dp = getPEDiffs(m, indiv.Bodies, envs)
+	}
 	return *indiv
 }
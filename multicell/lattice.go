@@ -0,0 +1,176 @@
+package multicell
+
+import (
+	"fmt"
+)
+
+// Cells on a lattice exchanging morphogens.
+/*
+   With Lattice > 0, the cells of a body sit on a chain (1) or on a grid of
+   LatticeW columns (2); cell c is at x = c % width, y = c / width. The
+   first NSignal units of layer Signal produce as many morphogens, whose
+   concentrations m in each cell change at every step of development as
+     m += Diffusion * sum_neighbours(m_n - m) + MorphDecay * (prod - m)
+   where prod is the state of the producing units at the previous step
+   (plus MorphSource for morphogen 0 in the cells at x = 0). The input "s"
+   of a layer is the local concentration of the morphogens. Boundaries are
+   closed (no flux). The concentrations at the end of development are kept
+   in Cell.X["s"].
+
+   With a Pattern, the selected traits (0 ... NSel-1) of the cues are
+   spatial patterns over the lattice, each along x or y (grid) with a random
+   sign: "gradient" (+ beyond a random threshold, - before it) or "stripes"
+   (of random width and phase). Other traits are the same in all cells.
+   Changes of environments flip the same traits in all cells, so the
+   patterns are kept.
+*/
+
+var Patterns = []string{"stripes", "gradient"}
+
+func (s *Settings) checkLattice() error {
+	switch {
+	case s.Lattice < 0 || s.Lattice > 2:
+		return fmt.Errorf("Lattice must be 0, 1, or 2")
+	case s.Lattice == 0 && s.Pattern != "":
+		return fmt.Errorf("Pattern %q without Lattice", s.Pattern)
+	case s.Lattice == 0:
+		return nil
+	case s.Signal == "":
+		return fmt.Errorf("Lattice without Signal (morphogen producing layer)")
+	case s.Lattice == 2 && (s.LatticeW <= 0 || s.NCells%s.LatticeW != 0):
+		return fmt.Errorf("Lattice 2: LatticeW must divide NCells")
+	case s.Diffusion < 0 || s.Diffusion*float64(2*s.Lattice) > 1:
+		return fmt.Errorf("Diffusion must be in [0, %g] (stability)", 1/float64(2*s.Lattice))
+	case s.MorphDecay < 0 || s.MorphDecay > 1:
+		return fmt.Errorf("MorphDecay must be in [0, 1]")
+	}
+	ok := s.Pattern == ""
+	for _, p := range Patterns {
+		ok = ok || s.Pattern == p
+	}
+	if !ok {
+		return fmt.Errorf("unknown pattern %q (known: %v)", s.Pattern, Patterns)
+	}
+	return nil
+}
+
+// Number of columns of the lattice.
+func (s *Settings) latticeWidth() int {
+	if s.Lattice == 2 {
+		return s.LatticeW
+	}
+	return s.NCells
+}
+
+// Position of cell c on the lattice.
+func (s *Settings) cellPos(c int) (int, int) {
+	w := s.latticeWidth()
+	return c % w, c / w
+}
+
+func (m *Model) setLattice() {
+	if m.Lattice == 0 {
+		return
+	}
+	w := m.latticeWidth()
+	h := m.NCells / w
+	m.nbrs = make([][]int, m.NCells)
+	for c := range m.nbrs {
+		x, y := m.cellPos(c)
+		if x > 0 {
+			m.nbrs[c] = append(m.nbrs[c], c-1)
+		}
+		if x < w-1 {
+			m.nbrs[c] = append(m.nbrs[c], c+1)
+		}
+		if y > 0 {
+			m.nbrs[c] = append(m.nbrs[c], c-w)
+		}
+		if y < h-1 {
+			m.nbrs[c] = append(m.nbrs[c], c+w)
+		}
+	}
+}
+
+// One step of production, diffusion and decay of the morphogens of all cells.
+func (m *Model) diffuseMorphogens(buf *devBuffers) {
+	for c := range m.nbrs {
+		cb := &buf.cells[c]
+		prod := cb.x0[m.signal][:m.nsig]
+		x, _ := m.cellPos(c)
+		for i, mc := range cb.sig {
+			lap := 0.0
+			for _, n := range m.nbrs[c] {
+				lap += buf.cells[n].sig[i] - mc
+			}
+			pr := prod[i]
+			if i == 0 && x == 0 {
+				pr += m.MorphSource
+			}
+			cb.morph[i] = mc + m.Diffusion*lap + m.MorphDecay*(pr-mc)
+		}
+	}
+	for c := range m.nbrs {
+		cb := &buf.cells[c]
+		cb.sig, cb.morph = cb.morph, cb.sig
+	}
+}
+
+// Cues with selected traits in the spatial Pattern.
+func PatternEnvs(m *Model) Cues {
+	envs := NewCues(m.NCells, m.NEnv)
+	w := m.latticeWidth()
+	h := m.NCells / w
+	for j := 0; j < m.NEnv; j++ {
+		if j >= m.NSel {
+			v := -m.CueMag
+			if m.run.cue.Float64() < 0.5 {
+				v = m.CueMag
+			}
+			for c := range envs {
+				envs[c][j] = v
+			}
+			continue
+		}
+
+		vertical := m.Lattice == 2 && m.run.cue.Intn(2) == 1
+		size := w
+		if vertical {
+			size = h
+		}
+		sign := 1.0
+		if m.run.cue.Intn(2) == 1 {
+			sign = -1.0
+		}
+		thresh, width, phase := 0, 1, 0
+		if m.Pattern == "gradient" {
+			thresh = 1 + m.run.cue.Intn(maxInt(size-1, 1))
+		} else {
+			width = 1 + m.run.cue.Intn(maxInt(size/2, 1))
+			phase = m.run.cue.Intn(2 * width)
+		}
+
+		for c := range envs {
+			x, y := m.cellPos(c)
+			if vertical {
+				x = y
+			}
+			on := x >= thresh
+			if m.Pattern == "stripes" {
+				on = ((x+phase)/width)%2 == 0
+			}
+			envs[c][j] = -sign * m.CueMag
+			if on {
+				envs[c][j] = sign * m.CueMag
+			}
+		}
+	}
+	return envs
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,493 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+)
+
+// Layered genotype-phenotype map.
+/*
+   Development computes the layers of Settings.Layers in order at every
+   step. A layer sums its inputs, each being the state of a layer (or the
+   cue) multiplied by a genome matrix or passed through as is, then applies
+   its activation function (scaled by omega) and its memory:
+     x = act(omega * sum_inputs(M * src));  x += (1 - Tau) * x_prev
+   An input reads the current step's state of an earlier layer, or, with
+   Prev, the previous step's state of any layer (recurrence). Sources "e"
+   (cue) and "e-p" (cue minus phenotype) are the environmental cue; "s" is
+   the signal from the other cells of the body: the mean of their states of
+   (the first NSignal units of) layer Settings.Signal at the previous step,
+   or the local concentrations of morphogens on a lattice (lattice.go). The
+   last layer must be "p", the phenotype; its output is multiplied by
+   CueMag. The states of layers f, g, and h are stored in Cell.F, G, H,
+   and those of other layers in Cell.X.
+
+   Matrices are named. E, F, G, H, J, and P are the fields of Genome (their
+   densities are DensityE ... DensityP); other names are stored in
+   Genome.Extra in the order of their first appearance, with the Density of
+   the input. Omega is 1/sqrt(expected number of inputs of a unit), with
+   weight 2 - Tau for an input layer with memory and 2 for "e-p".
+
+   If Layers is empty, the layers are those of the EFGHJP model selected by
+   WithCue, Pfback, FLayer, HLayer, and JLayer, with their slopes (omegas)
+   as before; with Layers, those switches, TauF/G/H and ActF/G/H/P are not
+   used. With Signal, the EFGHJP model gets the input S * s (density
+   DensityS) in the f layer.
+*/
+
+type LayerInput struct {
+	From    string  // Layer, "e", "e-p", or "s"
+	Matrix  string  `json:",omitempty"` // Genome matrix; "": identity (sizes must agree)
+	Prev    bool    `json:",omitempty"` // State of the previous step
+	Density float64 `json:",omitempty"` // Density of Matrix if not one of E ... P
+}
+
+type LayerSpec struct {
+	Name   string
+	Size   int     `json:",omitempty"` // 0: NGenes (NEnv for p)
+	Init   float64 `json:",omitempty"` // Initial state
+	Act    string  `json:",omitempty"` // Activation function (see activations); "": none
+	Tau    float64 `json:",omitempty"` // Memory; 0 or 1: none
+	Inputs []LayerInput
+}
+
+const (
+	srcCue      = -1 // "e"
+	srcCueMinus = -2 // "e-p"
+	srcSignal   = -3 // "s"
+)
+
+type devInput struct {
+	src  int  // Index of layer, srcCue, srcCueMinus, or srcSignal
+	prev bool // State of previous step
+	mat  int  // Index in genomeMats; -1: identity
+}
+
+type devLayer struct {
+	name   string
+	size   int
+	init   float64
+	act    func(float64) float64 // nil: none
+	omega  float64
+	scale  float64 // CueMag for p; 1 otherwise
+	tau    float64
+	noisy  bool // Intrinsic noise (see noise.go)
+	inputs []devInput
+}
+
+type matrixDim struct {
+	name       string
+	nrow, ncol int
+	density    float64 // Density in Settings (Extra) or -1 (E ... P)
+	used       bool
+}
+
+// Layers of the EFGHJP model.
+func (s *Settings) legacyLayers() []LayerSpec {
+	withE := s.WithCue || s.Pfback
+	fin := []LayerInput{{From: "g", Matrix: "G", Prev: true}}
+	if withE {
+		from := "e"
+		if s.Pfback {
+			from = "e-p"
+		}
+		fin = append(fin, LayerInput{From: from, Matrix: "E"})
+	}
+	if s.Signal != "" {
+		fin = append(fin, LayerInput{From: "s", Matrix: "S", Density: s.DensityS})
+	}
+	f := LayerSpec{Name: "f", Inputs: fin, Tau: 1} // Sum of inputs of g without F layer.
+	g := LayerSpec{Name: "g", Init: 1, Act: s.ActG, Tau: s.TauG, Inputs: []LayerInput{{From: "f"}}}
+	if s.FLayer {
+		f.Act = s.ActF
+		f.Tau = s.TauF
+		g.Inputs[0].Matrix = "F"
+	}
+	h := LayerSpec{Name: "h", Tau: 1, Inputs: []LayerInput{{From: "g"}}}
+	if s.HLayer {
+		h.Act = s.ActH
+		h.Tau = s.TauH
+		if s.JLayer {
+			h.Inputs = []LayerInput{{From: "g", Matrix: "H"}, {From: "h", Matrix: "J", Prev: true}}
+		} // Without J, h = act(g) as before.
+	}
+	p := LayerSpec{Name: "p", Act: s.ActP, Tau: 1, Inputs: []LayerInput{{From: "h", Matrix: "P"}}}
+
+	return []LayerSpec{f, g, h, p}
+}
+
+func (s *Settings) layerSpecs() []LayerSpec {
+	if len(s.Layers) == 0 {
+		return s.legacyLayers()
+	}
+	return s.Layers
+}
+
+func (s *Settings) layerSize(l LayerSpec) int {
+	switch {
+	case l.Size > 0:
+		return l.Size
+	case l.Name == "p":
+		return s.NEnv
+	}
+	return s.NGenes
+}
+
+// Matrices of the genome in the order of genomeMats.
+func (s *Settings) matrixDims() []matrixDim {
+	dims := []matrixDim{{"E", s.NGenes, s.NEnv, -1, false}, {"F", s.NGenes, s.NGenes, -1, false},
+		{"G", s.NGenes, s.NGenes, -1, false}, {"H", s.NGenes, s.NGenes, -1, false},
+		{"J", s.NGenes, s.NGenes, -1, false}, {"P", s.NEnv, s.NGenes, -1, false}}
+
+	specs := s.layerSpecs()
+	index := make(map[string]int)
+	for i, l := range specs {
+		index[l.Name] = i
+	}
+	for _, l := range specs {
+		for _, in := range l.Inputs {
+			if in.Matrix == "" {
+				continue
+			}
+			ncol := s.NEnv
+			if in.From == "s" {
+				ncol = s.signalSize()
+			} else if k, ok := index[in.From]; ok {
+				ncol = s.layerSize(specs[k])
+			}
+			dim := matrixDim{in.Matrix, s.layerSize(l), ncol, in.Density, true}
+			if k := matrixIndex(in.Matrix); k < 0 {
+				dims = append(dims, dim)
+			} else if len(s.Layers) > 0 { // EFGHJP model: dimensions as above
+				dim.density = -1
+				dims[k] = dim
+			}
+		}
+	}
+	return dims
+}
+
+// Number of units of layer Signal sent to other cells.
+func (s *Settings) signalSize() int {
+	for _, l := range s.layerSpecs() {
+		if l.Name == s.Signal {
+			if n := s.layerSize(l); s.NSignal == 0 || s.NSignal > n {
+				return n
+			}
+			return s.NSignal
+		}
+	}
+	return 0
+}
+
+func matrixIndex(name string) int {
+	if len(name) == 1 {
+		for i := range genomeLetters {
+			if name[0] == genomeLetters[i] {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Checks the layers (the EFGHJP model is valid except for Signal).
+func (s *Settings) checkLayers() error {
+	if s.Signal != "" {
+		ok := false
+		for _, l := range s.layerSpecs() {
+			ok = ok || l.Name == s.Signal
+		}
+		if !ok {
+			return fmt.Errorf("Signal: unknown layer %q", s.Signal)
+		}
+		if s.DensityS < 0 || s.DensityS > 1 {
+			return fmt.Errorf("DensityS must be in [0, 1]")
+		}
+		if s.NSignal < 0 {
+			return fmt.Errorf("NSignal must not be negative")
+		}
+	}
+	if len(s.Layers) == 0 {
+		return nil
+	}
+	if s.Layers[len(s.Layers)-1].Name != "p" {
+		return fmt.Errorf("Layers: the last layer must be p")
+	}
+	index := make(map[string]int)
+	mats := make(map[string]bool)
+	for i, l := range s.Layers {
+		what := fmt.Sprintf("Layers[%d] (%s)", i, l.Name)
+		if _, dup := index[l.Name]; dup || l.Name == "" || l.Name == "e" || l.Name == "e-p" || l.Name == "s" {
+			return fmt.Errorf("%s: empty, reserved, or duplicate name", what)
+		}
+		index[l.Name] = i
+		size := s.layerSize(l)
+		switch l.Name {
+		case "f", "g", "h":
+			if size != s.NGenes {
+				return fmt.Errorf("%s: size must be NGenes", what)
+			}
+		case "p":
+			if size != s.NEnv {
+				return fmt.Errorf("%s: size must be NEnv", what)
+			}
+		}
+		if _, ok := activations[l.Act]; !ok && l.Act != "" {
+			return fmt.Errorf("%s: unknown activation function %q", what, l.Act)
+		}
+		if l.Tau < 0 || l.Tau > 1 {
+			return fmt.Errorf("%s: Tau must be in [0, 1]", what)
+		}
+		if len(l.Inputs) == 0 {
+			return fmt.Errorf("%s: no inputs", what)
+		}
+	}
+	for i, l := range s.Layers {
+		what := fmt.Sprintf("Layers[%d] (%s)", i, l.Name)
+		for _, in := range l.Inputs {
+			ncol := s.NEnv
+			if in.From == "s" && s.Signal == "" {
+				return fmt.Errorf("%s: input s without Signal", what)
+			} else if in.From == "s" {
+				ncol = s.signalSize()
+			}
+			if in.From == "e" || in.From == "e-p" || in.From == "s" {
+				if in.Prev {
+					return fmt.Errorf("%s: input %s cannot be Prev", what, in.From)
+				}
+			} else if k, ok := index[in.From]; !ok {
+				return fmt.Errorf("%s: unknown input layer %q", what, in.From)
+			} else if k >= i && !in.Prev {
+				return fmt.Errorf("%s: input %s is not an earlier layer; use Prev", what, in.From)
+			} else {
+				ncol = s.layerSize(s.Layers[k])
+			}
+			switch {
+			case in.Matrix == "" && ncol != s.layerSize(l):
+				return fmt.Errorf("%s: identity input %s of different size", what, in.From)
+			case in.Matrix != "" && mats[in.Matrix]:
+				return fmt.Errorf("%s: matrix %s used more than once", what, in.Matrix)
+			case in.Density < 0 || in.Density > 1:
+				return fmt.Errorf("%s: density of %s must be in [0, 1]", what, in.Matrix)
+			}
+			if in.Matrix != "" {
+				mats[in.Matrix] = true
+			}
+		}
+	}
+	if len(s.matrixDims()) > 256 {
+		return fmt.Errorf("Layers: too many matrices")
+	}
+	return nil
+}
+
+// Builds the layers and per-matrix quantities of a Model.
+func (m *Model) setLayers() {
+	s := &m.Settings
+	specs := s.layerSpecs()
+	dims := s.matrixDims()
+
+	index := make(map[string]int)
+	for i, l := range specs {
+		index[l.Name] = i
+	}
+	mats := make(map[string]int)
+	for i, d := range dims {
+		mats[d.name] = i
+	}
+
+	m.matDensity = make([]float64, len(dims))
+	m.initDensity = make([]float64, len(dims))
+	legacy := []float64{m.DensityE, m.DensityF, m.DensityG, m.DensityH, m.DensityJ, m.DensityP}
+	for i, d := range dims {
+		switch {
+		case i < nGenomeMats && (len(s.Layers) == 0 || d.used):
+			m.matDensity[i] = legacy[i]
+		case d.used:
+			m.matDensity[i] = d.density
+		}
+		m.initDensity[i] = m.matDensity[i]
+	}
+	if len(s.Layers) == 0 {
+		m.initDensity[2] = m.DensityF // G is randomized with the density of F as before.
+	}
+
+	m.layers = make([]devLayer, len(specs))
+	for i, l := range specs {
+		dl := devLayer{name: l.Name, size: s.layerSize(l), init: l.Init, omega: 1, scale: 1, tau: l.Tau}
+		if dl.tau == 0 {
+			dl.tau = 1
+		}
+		if l.Act != "" {
+			dl.act = activations[l.Act].fn
+		}
+		if l.Name == "p" {
+			dl.scale = s.CueMag
+		}
+		dl.noisy = s.noisyDev() && i < len(specs)-1
+		for _, in := range l.Inputs {
+			di := devInput{prev: in.Prev, mat: -1}
+			switch in.From {
+			case "e":
+				di.src = srcCue
+			case "e-p":
+				di.src = srcCueMinus
+			case "s":
+				di.src = srcSignal
+			default:
+				di.src = index[in.From]
+			}
+			if in.Matrix != "" {
+				di.mat = mats[in.Matrix]
+			}
+			dl.inputs = append(dl.inputs, di)
+		}
+		m.layers[i] = dl
+	}
+
+	m.signal = -1
+	if s.Signal != "" {
+		m.signal = index[s.Signal]
+		m.nsig = s.signalSize()
+	}
+
+	// Matrices used in development, for FlatVec and alike.
+	m.activeMats = m.activeMats[:0]
+	for i, d := range dims {
+		if len(s.Layers) > 0 || i >= nGenomeMats {
+			if d.used {
+				m.activeMats = append(m.activeMats, i)
+			}
+			continue
+		}
+		switch genomeLetters[i] {
+		case 'E':
+			d.used = m.withE
+		case 'F':
+			d.used = s.FLayer
+		case 'H':
+			d.used = s.HLayer
+		case 'J':
+			d.used = s.HLayer && s.JLayer
+		default:
+			d.used = true
+		}
+		if d.used {
+			m.activeMats = append(m.activeMats, i)
+		}
+	}
+
+	if len(s.Layers) == 0 {
+		m.legacyOmegas(dims)
+	} else {
+		m.fanInOmegas(specs, dims)
+	}
+}
+
+// Slopes of the EFGHJP model.
+func (m *Model) legacyOmegas(dims []matrixDim) {
+	s := &m.Settings
+	ngenes := float64(s.NGenes)
+	nenv := float64(s.NEnv)
+	from_g := s.DensityG * ngenes
+	var omegaF, omegaG, omegaH, omegaP float64
+
+	if m.withE {
+		from_e := s.DensityE * nenv
+		if s.WithCue && s.Pfback {
+			omegaF = 1.0 / math.Sqrt(2*from_e+from_g*(2-s.TauG))
+		} else {
+			omegaF = 1.0 / math.Sqrt(from_e+from_g*(2-s.TauG))
+		}
+	} else {
+		omegaF = 1.0 / math.Sqrt(from_g*(2-s.TauG))
+	}
+
+	if s.FLayer {
+		omegaG = 1.0 / math.Sqrt(s.DensityF*ngenes*(2-s.TauF))
+	} else {
+		efac := 1.0
+		if s.WithCue && s.Pfback {
+			efac = 2.0
+		}
+		omegaG = 1.0 / math.Sqrt(s.DensityG*ngenes*(2-s.TauG)+efac*m.DensityE*nenv)
+	}
+
+	if s.HLayer {
+		if s.JLayer {
+			omegaH = 1.0 / math.Sqrt(from_g*((2-s.TauG)+(2-s.TauH)))
+		} else {
+			omegaH = 1.0 / math.Sqrt(from_g*(2-s.TauG))
+		}
+		omegaP = 1.0 / math.Sqrt(s.DensityP*ngenes*(2-s.TauH))
+	} else {
+		omegaH = 0.0
+		omegaP = 1.0 / math.Sqrt(s.DensityP*ngenes*(2-s.TauG))
+	}
+	/* // Trying not calling EMA for NoDev instead.
+	if s.MaxDevStep == 1 {
+		omegaP *= 10.0 //Arbitrary factor to increase sensitivity of NoDev.
+	}
+	*/
+
+	if m.signal >= 0 { // Signal S * s in f (or in g through f without F layer)
+		fs := m.inputFanIn(0, len(m.layers[0].inputs)-1, dims)
+		if s.FLayer {
+			omegaF = 1.0 / math.Sqrt(1/(omegaF*omegaF)+fs)
+		} else {
+			omegaG = 1.0 / math.Sqrt(1/(omegaG*omegaG)+fs)
+		}
+	}
+
+	rms := s.weightRMS()
+	d := DefaultSettings()
+	m.layers[0].omega = omegaF * activationGain(s.ActF, d.ActF) / rms
+	m.layers[1].omega = omegaG * activationGain(s.ActG, d.ActG) / rms
+	m.layers[2].omega = omegaH * activationGain(s.ActH, d.ActH) / rms
+	m.layers[3].omega = omegaP * activationGain(s.ActP, d.ActP) / rms
+}
+
+// Slopes from the expected number of inputs of the units of each layer.
+func (m *Model) fanInOmegas(specs []LayerSpec, dims []matrixDim) {
+	rms := m.weightRMS()
+	d := DefaultSettings()
+	for i, l := range specs {
+		fanin := 0.0
+		for k := range l.Inputs {
+			fanin += m.inputFanIn(i, k, dims)
+		}
+		if l.Act == "" || fanin == 0 {
+			continue
+		}
+		ref := d.ActG
+		if l.Name == "p" {
+			ref = d.ActP
+		}
+		m.layers[i].omega = activationGain(l.Act, ref) / (rms * math.Sqrt(fanin))
+	}
+}
+
+// Expected number of (unit) inputs of a unit of layer i through input k.
+func (m *Model) inputFanIn(i, k int, dims []matrixDim) float64 {
+	in := m.layers[i].inputs[k]
+	w := 1.0
+	switch in.src {
+	case srcCue:
+		if !m.WithCue {
+			w = 0
+		}
+	case srcCueMinus:
+		if m.WithCue {
+			w = 2
+		}
+	case srcSignal:
+		w = 2 - m.layers[m.signal].tau
+	default:
+		w = 2 - m.layers[in.src].tau
+	}
+	if in.mat >= 0 {
+		w *= m.matDensity[in.mat] * float64(dims[in.mat].ncol)
+	}
+	return w
+}
@@ -0,0 +1,209 @@
+package multicell
+
+import (
+	"fmt"
+	"io"
+)
+
+// Versions of the population file format.
+/*
+   0: unversioned files. Since ark6, cues and phenotypes have dimension nenv;
+      before ark6 they had nenv + ncells (cell identity appended to the cue).
+      Both are version 0; they are told apart by the dimension of the cues.
+   1: FormatVersion recorded.
+   2: Former constants (CueMag, EpsDev, CCStep, BaseSelStrength, SelDevStep,
+      MinWagnerFitness) are in Settings; Provenance recorded.
+      Older files get the values of the constants.
+   3: Activation functions (ActF, ActG, ActH, ActP) are in Settings.
+      Older files get lecunatan for f, g, h and tanh for p.
+   4: Weight model and perturbations (WeightModel, WeightSD, PerturbRate,
+      PerturbStep) are in Settings. Older files get ternary weights.
+   5: Layer stack (Layers) in Settings, Genome.Extra, and Cell.X.
+      Older files have none; they use the EFGHJP model as before.
+   6: Cell-cell signaling (Signal, DensityS) in Settings. Older files have
+      no signaling.
+   7: Lattice and morphogens (NSignal, Lattice, LatticeW, Diffusion,
+      MorphDecay, MorphSource, Pattern) in Settings. Older files have none.
+   8: Developmental engine (DevEngine, DevDt, DevTol) in Settings. Older
+      files get the map.
+   9: Intrinsic noise of development (DevNoise, DevNoiseSD) in Settings.
+      Older files have none.
+  10: Environments of development (NEnvs, FitAgg) in Settings, and
+      Population.Envs. Older files have Anc and Nov only.
+  11: One genome for all bodies (SharedGenome) in Settings; the genome is
+      then stored in the first body only. Older files have none.
+  12: Fitness function (Fitness) in Settings. Older files get gaussL1 with
+      the cost of development.
+  13: Selection (Selection, Tournament, TruncFrac, Elite) in Settings.
+      Older files get the Wagner scheme without elites.
+  14: Recombination (Recombination, CrossRate, CrossPoints, RecombRate,
+      LinkageMap) in Settings. Older files get free recombination.
+  15: Mutation rates by matrix name (MutRates) in Settings, and Frozen may
+      name any matrix. Older files have none.
+  16: Sparse matrices stored in CSR form (RowPtr, Col, Val) rather than as
+      arrays of maps (Mat); both are read.
+*/
+const PopFormatVersion = 16
+
+type VersionError struct {
+	Version int
+	Reason  string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("population file format version %d: %s", e.Version, e.Reason)
+}
+
+const (
+	layoutCurrent = iota
+	layoutPreArk6 // cue and phenotype dimension nenv + ncells
+	layoutUnknown
+)
+
+func (pop *Population) detectLayout() (int, string) {
+	s := pop.Params
+	if s.NGenes == 0 || s.NEnv == 0 || s.NCells == 0 {
+		return layoutUnknown, "no Settings recorded in file"
+	}
+	if len(pop.Indivs) > 0 && len(pop.Indivs[0].Bodies) == 0 {
+		return layoutUnknown, "individuals without Bodies (layout before Anc/Nov bodies); genomes and states cannot be recovered"
+	}
+	if len(pop.AncEnvs) == 0 {
+		return layoutUnknown, "no environmental cues recorded in file"
+	}
+
+	switch len(pop.AncEnvs[0]) {
+	case s.NEnv:
+		return layoutCurrent, ""
+	case s.NEnv + s.NCells:
+		return layoutPreArk6, "cue and phenotype dimension is nenv+ncells (before ark6)"
+	}
+	return layoutUnknown, fmt.Sprintf("cue dimension %d matches neither nenv (%d) nor nenv+ncells (%d)",
+		len(pop.AncEnvs[0]), s.NEnv, s.NEnv+s.NCells)
+}
+
+// Checks the version of a freshly decoded population.
+func (pop *Population) checkVersion() error {
+	if pop.FormatVersion > PopFormatVersion {
+		return &VersionError{pop.FormatVersion, fmt.Sprintf("newer than supported (%d)", PopFormatVersion)}
+	}
+	if pop.FormatVersion == 0 {
+		layout, reason := pop.detectLayout()
+		if layout != layoutCurrent {
+			return &VersionError{0, reason + "; convert it with popmigrate"}
+		}
+	}
+	return nil
+}
+
+// Settings recorded since each format version; files of older versions
+// get the former values (the defaults d).
+var formerSettings = []struct {
+	version int
+	apply   func(s *Settings, d Settings)
+}{
+	{2, func(s *Settings, d Settings) { // Former constants
+		s.CueMag, s.EpsDev, s.CCStep = d.CueMag, d.EpsDev, d.CCStep
+		s.BaseSelStrength, s.SelDevStep, s.MinWagnerFitness = d.BaseSelStrength, d.SelDevStep, d.MinWagnerFitness
+	}},
+	{3, func(s *Settings, d Settings) { s.ActF, s.ActG, s.ActH, s.ActP = d.ActF, d.ActG, d.ActH, d.ActP }},
+	{4, func(s *Settings, d Settings) {
+		s.WeightModel, s.WeightSD, s.PerturbRate, s.PerturbStep = d.WeightModel, d.WeightSD, d.PerturbRate, d.PerturbStep
+	}},
+	{6, func(s *Settings, d Settings) { s.DensityS = d.DensityS }},
+	{7, func(s *Settings, d Settings) { s.Diffusion, s.MorphDecay = d.Diffusion, d.MorphDecay }},
+	{8, func(s *Settings, d Settings) { s.DevEngine, s.DevDt, s.DevTol = d.DevEngine, d.DevDt, d.DevTol }},
+	{9, func(s *Settings, d Settings) { s.DevNoise, s.DevNoiseSD = d.DevNoise, d.DevNoiseSD }},
+	{10, func(s *Settings, d Settings) { s.NEnvs, s.FitAgg = d.NEnvs, d.FitAgg }},
+	{12, func(s *Settings, d Settings) { s.Fitness = d.Fitness }},
+	{13, func(s *Settings, d Settings) {
+		s.Selection, s.Tournament, s.TruncFrac, s.Elite = d.Selection, d.Tournament, d.TruncFrac, d.Elite
+	}},
+	{14, func(s *Settings, d Settings) {
+		s.Recombination, s.CrossRate, s.CrossPoints, s.RecombRate = d.Recombination, d.CrossRate, d.CrossPoints, d.RecombRate
+		s.LinkageMap = nil
+	}},
+}
+
+// Fills in the settings that files of older versions do not record.
+func upgradeSettings(version int, s *Settings) {
+	d := DefaultSettings()
+	for _, f := range formerSettings {
+		if version < f.version {
+			f.apply(s, d)
+		}
+	}
+}
+
+// Brings a freshly decoded (and checked) population to the current version.
+func (pop *Population) upgrade() {
+	upgradeSettings(pop.FormatVersion, &pop.Params)
+	pop.FormatVersion = PopFormatVersion
+	pop.shareGenomes()
+}
+
+func truncVec(v Vec, n int) Vec {
+	if len(v) > n {
+		return v[0:n]
+	}
+	return v
+}
+
+func truncCues(cues Cues, n int) {
+	for i, c := range cues {
+		cues[i] = truncVec(c, n)
+	}
+}
+
+// Drops the cell identity part of cues, phenotypes, and the E and P matrices.
+func (pop *Population) migratePreArk6() {
+	nenv := pop.Params.NEnv
+	truncCues(pop.AncEnvs, nenv)
+	truncCues(pop.NovEnvs, nenv)
+	for _, indiv := range pop.Indivs {
+		for b := range indiv.Bodies {
+			body := &indiv.Bodies[b]
+			for i, cell := range body.Cells {
+				body.Cells[i].E = truncVec(cell.E, nenv)
+				body.Cells[i].P = truncVec(cell.P, nenv)
+				body.Cells[i].Pvar = truncVec(cell.Pvar, nenv)
+			}
+			body.Genome.E.Resize(body.Genome.E.NRow(), nenv)
+			if body.Genome.P.NRow() > nenv {
+				body.Genome.P.Resize(nenv, body.Genome.P.Ncol)
+			}
+		}
+	}
+}
+
+// MigratePopulation reads a population in any known layout and converts it
+// to the current one. It returns an error (*VersionError) for layouts that
+// cannot be converted.
+func MigratePopulation(r io.Reader) (Population, []string, error) {
+	var notes []string
+	pop := Population{Params: DefaultSettings()}
+	err := loadGzJSON(r, &pop)
+	if err != nil {
+		return pop, notes, err
+	}
+	if pop.FormatVersion > PopFormatVersion {
+		return pop, notes, &VersionError{pop.FormatVersion, fmt.Sprintf("newer than supported (%d)", PopFormatVersion)}
+	}
+
+	if pop.FormatVersion == 0 {
+		layout, reason := pop.detectLayout()
+		switch layout {
+		case layoutUnknown:
+			return pop, notes, &VersionError{0, reason + "; cannot migrate"}
+		case layoutPreArk6:
+			pop.migratePreArk6()
+			notes = append(notes, "dropped cell identity from cues, phenotypes, E and P ("+reason+")")
+		}
+	}
+	if pop.FormatVersion < PopFormatVersion {
+		notes = append(notes, fmt.Sprintf("upgraded format version %d to %d", pop.FormatVersion, PopFormatVersion))
+	}
+	pop.upgrade()
+
+	return pop, notes, pop.Validate()
+}
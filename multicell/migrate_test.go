@@ -0,0 +1,104 @@
+package multicell
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Appends the cell identity part (ncells entries) of layouts before ark6.
+func withCellId(v Vec, ncells int) Vec {
+	if v == nil {
+		return nil
+	}
+	return append(CopyVec(v), NewVec(ncells)...)
+}
+
+// Encodes pop in the unversioned format of the baseline, with the layout
+// before ark6 if preArk6.
+func baselineFile(t *testing.T, pop Population, preArk6 bool) []byte {
+	t.Helper()
+	if preArk6 {
+		pop = pop.Copy()
+		ncells := pop.Params.NCells
+		for i := range pop.AncEnvs {
+			pop.AncEnvs[i] = withCellId(pop.AncEnvs[i], ncells)
+			pop.NovEnvs[i] = withCellId(pop.NovEnvs[i], ncells)
+		}
+		for _, indiv := range pop.Indivs {
+			for b := range indiv.Bodies {
+				body := &indiv.Bodies[b]
+				for i, cell := range body.Cells {
+					body.Cells[i].E = withCellId(cell.E, ncells)
+					body.Cells[i].P = withCellId(cell.P, ncells)
+					body.Cells[i].Pvar = withCellId(cell.Pvar, ncells)
+				}
+				E, P := &body.Genome.E, &body.Genome.P
+				E.Resize(E.NRow(), E.Ncol+ncells)
+				for i := 0; i < E.NRow(); i++ {
+					E.Set(i, pop.Params.NEnv, 1)
+				}
+				P.Resize(P.NRow()+ncells, P.Ncol)
+				P.Set(pop.Params.NEnv, 0, 1)
+			}
+		}
+	}
+
+	data, err := json.Marshal(pop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	delete(fields, "FormatVersion")
+	var buf bytes.Buffer
+	if err := saveGzJSON(&buf, fields, gzip.BestSpeed); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMigratePopulation(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	s.NCells = 2
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+
+	var errVersion *VersionError
+	for _, preArk6 := range []bool{false, true} {
+		data := baselineFile(t, pop, preArk6)
+
+		var pop0 Population
+		err := pop0.Load(bytes.NewReader(data))
+		if preArk6 != errors.As(err, &errVersion) {
+			t.Errorf("preArk6=%v: Load: %v", preArk6, err)
+		}
+
+		pop1, notes, err := MigratePopulation(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("preArk6=%v: %v", preArk6, err)
+		}
+		var buf bytes.Buffer
+		if err := pop1.Save(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var pop2 Population
+		if err := pop2.Load(&buf); err != nil {
+			t.Fatalf("preArk6=%v: migrated file: %v", preArk6, err)
+		}
+		if !reflect.DeepEqual(pop2, pop) {
+			t.Errorf("preArk6=%v: migrated population differs (%v)", preArk6, notes)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Intrinsic noise of development.
+/*
+   With DevNoise "additive" or "multiplicative", the states x of all layers
+   but p (f, g, and h in the EFGHJP model) get Gaussian noise after each
+   update, as in the Euler-Maruyama scheme of a Langevin equation:
+     additive:        x += DevNoiseSD * sqrt(dt) * N(0, 1)
+     multiplicative:  x += DevNoiseSD * sqrt(dt) * x * N(0, 1)
+   where dt = 1 for the map and DevDt for rk4. Noise is drawn from the
+   random stream of the individual, like the noise of cues (SDNoise). With
+   noise, rk4 detects convergence with the moving variance of p (as the map
+   does) at unit times, since dx/dt does not vanish; dopri needs
+   deterministic dynamics. That variance includes the noise passed on to p,
+   so EpsDev must be above it for development to converge at all.
+*/
+
+var DevNoises = []string{"none", "additive", "multiplicative"}
+
+func (s *Settings) checkDevNoise() error {
+	ok := false
+	for _, n := range DevNoises {
+		ok = ok || s.DevNoise == n
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown DevNoise %q (known: %v)", s.DevNoise, DevNoises)
+	case s.DevNoiseSD < 0:
+		return fmt.Errorf("DevNoiseSD must not be negative")
+	case s.DevNoise != "none" && s.DevEngine == "dopri":
+		return fmt.Errorf("DevNoise %s: not with DevEngine dopri", s.DevNoise)
+	}
+	return nil
+}
+
+// Whether development has intrinsic noise.
+func (s *Settings) noisyDev() bool {
+	return s.DevNoise != "none" && s.DevNoiseSD > 0
+}
+
+// Adds the noise of a step of size dt to state x.
+func (m *Model) addDevNoise(x Vec, dt float64, rng *rand.Rand) {
+	sd := m.DevNoiseSD * math.Sqrt(dt)
+	if m.DevNoise == "multiplicative" {
+		for i, v := range x {
+			x[i] += sd * v * rng.NormFloat64()
+		}
+		return
+	}
+	for i := range x {
+		x[i] += sd * rng.NormFloat64()
+	}
+}
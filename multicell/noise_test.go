@@ -0,0 +1,105 @@
+package multicell
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestAddDevNoise(t *testing.T) {
+	const n = 20000
+	tests := []struct {
+		name   string
+		noise  string
+		x0, dt float64
+		sd     float64 // of the increments
+	}{
+		{"additive", "additive", 2, 1, 0.1},
+		{"additive of zero", "additive", 0, 1, 0.1},
+		{"additive dt", "additive", 2, 0.25, 0.05},
+		{"multiplicative", "multiplicative", 2, 1, 0.2},
+		{"multiplicative of negative", "multiplicative", -0.5, 1, 0.05},
+		{"multiplicative of zero", "multiplicative", 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.DevNoise = tt.noise
+			s.DevNoiseSD = 0.1
+			m := NewModel(s)
+			x := make(Vec, n)
+			for i := range x {
+				x[i] = tt.x0
+			}
+			m.addDevNoise(x, tt.dt, rand.New(rand.NewSource(1)))
+			var sum, sum2 float64
+			for _, v := range x {
+				d := v - tt.x0
+				sum += d
+				sum2 += d * d
+			}
+			mean := sum / n
+			sd := math.Sqrt(sum2/n - mean*mean)
+			if math.Abs(mean) > 5*tt.sd/math.Sqrt(n) || math.Abs(sd-tt.sd) > 0.05*tt.sd {
+				t.Errorf("increments: mean %g, sd %g; want 0, %g", mean, sd, tt.sd)
+			}
+		})
+	}
+}
+
+func TestCheckDevNoise(t *testing.T) {
+	tests := []struct {
+		name   string
+		noise  string
+		sd     float64
+		engine string
+		ok     bool
+	}{
+		{"none", "none", 0, "map", true},
+		{"additive with rk4", "additive", 0.1, "rk4", true},
+		{"multiplicative", "multiplicative", 0.1, "map", true},
+		{"unknown", "pink", 0.1, "map", false},
+		{"negative sd", "additive", -0.1, "map", false},
+		{"with dopri", "additive", 0.1, "dopri", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.DevNoise = tt.noise
+			s.DevNoiseSD = tt.sd
+			s.DevEngine = tt.engine
+			if err := s.checkDevNoise(); (err == nil) != tt.ok {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
+
+// Intrinsic noise changes development, reproducibly with the stream of the
+// individual.
+func TestDevelopNoise(t *testing.T) {
+	develop := func(noise string) Vec {
+		s := DefaultSettings()
+		s.MaxPop = 1
+		s.NGenes = 20
+		s.NEnv = 16
+		s.NSel = 4
+		s.DevNoise = noise
+		s.DevNoiseSD = 0.01
+		s.EpsDev = 0.1
+		m := NewModel(s)
+		pop := NewPopulation(m)
+		pop.RandomizeGenome(m)
+		pop.SetRandomNovEnvs(m)
+		indiv := pop.Indivs[0].Develop(m, pop.AncEnvs, pop.NovEnvs, rand.New(rand.NewSource(3)))
+		return indiv.Bodies[INovEnv].Cells[0].P
+	}
+	p0, p1, p2 := develop("none"), develop("additive"), develop("additive")
+	if reflect.DeepEqual(p0, p1) {
+		t.Error("noise does not change the phenotype")
+	}
+	if !reflect.DeepEqual(p1, p2) {
+		t.Error("development with the same stream differs")
+	}
+}
@@ -0,0 +1,98 @@
+package multicell
+
+import (
+	"fmt"
+	"io"
+)
+
+// Observers of evolution.
+/*
+   Evolve calls BeginEpoch before the first generation of an epoch, Observe
+   after each generation has developed (pop.Gen is the generation), and
+   EndEpoch with the population that Evolve returns (offspring of the last
+   generation, not yet developed). An error from an observer stops Evolve.
+   Observers must not modify the population.
+*/
+type Observer interface {
+	BeginEpoch(m *Model, pop *Population) error
+	Observe(m *Model, pop *Population, stats PopStats) error
+	EndEpoch(m *Model, pop *Population) error
+}
+
+// NopObserver does nothing; embed it to implement only some of the methods.
+type NopObserver struct{}
+
+func (NopObserver) BeginEpoch(m *Model, pop *Population) error              { return nil }
+func (NopObserver) Observe(m *Model, pop *Population, stats PopStats) error { return nil }
+func (NopObserver) EndEpoch(m *Model, pop *Population) error                { return nil }
+
+// TrajWriter writes the trajectory of population statistics (traj.dat).
+type TrajWriter struct {
+	NopObserver
+	W io.Writer
+}
+
+func (tw TrajWriter) BeginEpoch(m *Model, pop *Population) error {
+	header := "#Epoch\tGen\tNpop\tPhenoEnvDot \tMeanErr1 \tMeanErr0 \tMeanDp1e0 \tMeanDp0e1 \tFitness \tWag_Fit \tObs_Plas \tDiversity \tNdev"
+	if m.NEnvs > NBodies { // Fitness in each environment, then Dp of each pair
+		for k := 0; k < m.NEnvs; k++ {
+			header += fmt.Sprintf(" \tFitness%d", k)
+		}
+		for k := 0; k < m.NEnvs; k++ {
+			for l := 0; l < m.NEnvs; l++ {
+				header += fmt.Sprintf(" \tMeanDp%de%d", k, l)
+			}
+		}
+	}
+	_, err := fmt.Fprintln(tw.W, header) //header
+	return err
+}
+
+func (tw TrajWriter) Observe(m *Model, pop *Population, pstat PopStats) error {
+	line := fmt.Sprintf("%d\t%d\t%d\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e", pop.Epoch, pop.Gen, len(pop.Indivs), pstat.PEDot, pstat.PErr1, pstat.PErr0, pstat.PED10, pstat.PED01, pstat.Fitness, pstat.WagFit, pstat.Plasticity, pstat.Div, pstat.NDevStep)
+	for _, f := range pstat.BodyFit {
+		line += fmt.Sprintf("\t%e", f)
+	}
+	for _, dp := range pstat.PEDiff {
+		for _, d := range dp {
+			line += fmt.Sprintf("\t%e", d)
+		}
+	}
+	_, err := fmt.Fprintln(tw.W, line)
+	return err
+}
+
+// ProgressPrinter prints a line of progress per generation to stdout.
+type ProgressPrinter struct {
+	NopObserver
+}
+
+func (ProgressPrinter) Observe(m *Model, pop *Population, pstat PopStats) error {
+	fmt.Printf("Evolve: %d\t<ME1>: %e\t<ME0>: %e\t DevStep: %e", pop.Gen, pstat.PErr1, pstat.PErr0, pstat.NDevStep)
+	return nil
+}
+
+// PopExporter exports the population of each generation to
+// Basename_<epoch>_<gen>.json.gz.
+type PopExporter struct {
+	NopObserver
+	Basename string
+}
+
+func (pe PopExporter) Observe(m *Model, pop *Population, pstat PopStats) error {
+	filename := fmt.Sprintf("%s_%2.2d_%3.3d.json.gz", pe.Basename, pop.Epoch, pop.Gen)
+	return pop.ExportPopGz(filename)
+}
+
+// An archive records the population of each generation.
+func (ar *ArchiveWriter) BeginEpoch(m *Model, pop *Population) error {
+	return nil
+}
+
+func (ar *ArchiveWriter) Observe(m *Model, pop *Population, pstat PopStats) error {
+	return ar.Append(pop, m.Seed())
+}
+
+func (ar *ArchiveWriter) EndEpoch(m *Model, pop *Population) error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package multicell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Records the calls of Evolve; fails at the call numbered failAt (from 1).
+type callRecorder struct {
+	calls  []string
+	failAt int
+}
+
+var errObserver = errors.New("observer failed")
+
+func (r *callRecorder) record(call string) error {
+	r.calls = append(r.calls, call)
+	if len(r.calls) == r.failAt {
+		return errObserver
+	}
+	return nil
+}
+
+func (r *callRecorder) BeginEpoch(m *Model, pop *Population) error {
+	return r.record(fmt.Sprint("begin ", pop.Epoch))
+}
+
+func (r *callRecorder) Observe(m *Model, pop *Population, stats PopStats) error {
+	return r.record(fmt.Sprint("observe ", pop.Epoch, " ", pop.Gen))
+}
+
+func (r *callRecorder) EndEpoch(m *Model, pop *Population) error {
+	return r.record(fmt.Sprint("end ", pop.Epoch))
+}
+
+func TestEvolveObservers(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+
+	all := []string{"begin 3", "observe 3 1", "observe 3 2", "end 3"}
+	for failAt := 0; failAt <= len(all); failAt++ {
+		rec := &callRecorder{failAt: failAt}
+		_, err := pop.Evolve(m, 2, 3, rec)
+		want := all
+		if failAt > 0 {
+			want = all[:failAt]
+		}
+		if !reflect.DeepEqual(rec.calls, want) {
+			t.Errorf("failAt=%d: calls %v, want %v", failAt, rec.calls, want)
+		}
+		if (failAt > 0) != errors.Is(err, errObserver) {
+			t.Errorf("failAt=%d: error %v", failAt, err)
+		}
+	}
+}
+
+// Observers write the population they are given without changing it.
+func TestOutputsKeepPopulation(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+	pop.FormatVersion = 0 // As loaded from a file before versioning
+	want := pop.Copy()
+
+	var buf bytes.Buffer
+	if err := pop.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pop, want) {
+		t.Error("Save changed the population")
+	}
+
+	ar, err := CreateArchive(filepath.Join(t.TempDir(), "pop.evar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+	if err := ar.Append(&pop, m.Seed()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pop, want) {
+		t.Error("Append changed the population")
+	}
+}
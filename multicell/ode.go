@@ -0,0 +1,261 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Continuous-time development.
+/*
+   DevEngine "map" (default) is the discrete map of devCell. "rk4" (fixed
+   step DevDt) and "dopri" (Dormand-Prince 5(4) with adaptive steps; initial
+   step DevDt, tolerance DevTol) integrate the same network as ODEs:
+     dx/dt = act(omega * sum_inputs) - Tau * x
+   for each layer x, so that the Euler step of size 1 is the map with its
+   leak (1 - Tau) and both have the same fixed points. All inputs are the
+   current states ("Prev" makes no difference), and "e-p" uses the state of
+   p rather than its moving average. Development stops at a steady state,
+   when max |dx/dt| < EpsDev, or at time MaxDevStep; NDevStep is the time
+   taken (rounded up), in units of map steps. DevTol must be well below
+   EpsDev, or the adaptive steps may hover about a steady state without
+   detecting it. Signaling between cells needs the map.
+*/
+
+var DevEngines = []string{"map", "rk4", "dopri"}
+
+func (s *Settings) checkDevEngine() error {
+	ok := false
+	for _, e := range DevEngines {
+		ok = ok || s.DevEngine == e
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown DevEngine %q (known: %v)", s.DevEngine, DevEngines)
+	case s.DevEngine == "map":
+		return nil
+	case s.DevDt <= 0 || s.DevTol <= 0:
+		return fmt.Errorf("DevEngine %s: DevDt and DevTol must be positive", s.DevEngine)
+	case s.Signal != "":
+		return fmt.Errorf("DevEngine %s: signaling between cells needs the map", s.DevEngine)
+	}
+	return nil
+}
+
+// Work vectors of the integrators.
+type odeBuffers struct {
+	off        []int // Offset of each layer in y
+	y, y1, tmp Vec
+	k          [7]Vec
+	sum, mx    []Vec // Per layer
+	e_p        Vec
+}
+
+func newODEBuffers(m *Model) *odeBuffers {
+	ob := &odeBuffers{e_p: NewVec(m.NEnv)}
+	n := 0
+	for _, l := range m.layers {
+		ob.off = append(ob.off, n)
+		n += l.size
+		ob.sum = append(ob.sum, NewVec(l.size))
+		ob.mx = append(ob.mx, NewVec(l.size))
+	}
+	ob.y, ob.y1, ob.tmp = NewVec(n), NewVec(n), NewVec(n)
+	for i := range ob.k {
+		ob.k[i] = NewVec(n)
+	}
+	return ob
+}
+
+// State of layer k in y.
+func (ob *odeBuffers) layer(m *Model, y Vec, k int) Vec {
+	return y[ob.off[k] : ob.off[k]+m.layers[k].size]
+}
+
+// dy = dx/dt at y.
+func (m *Model) odeDeriv(mats []*Spmat, cue, y, dy Vec, ob *odeBuffers) {
+	for k := range m.layers {
+		l := &m.layers[k]
+		x := ob.sum[k]
+		for n, in := range l.inputs {
+			var src Vec
+			switch in.src {
+			case srcCue:
+				src = cue
+			case srcCueMinus:
+				DiffVecs(ob.e_p, cue, ob.layer(m, y, len(m.layers)-1))
+				src = ob.e_p
+			default:
+				src = ob.layer(m, y, in.src)
+			}
+			if in.mat >= 0 {
+				if n == 0 {
+					MultMatVec(x, *mats[in.mat], src)
+					continue
+				}
+				MultMatVec(ob.mx[k], *mats[in.mat], src)
+				src = ob.mx[k]
+			}
+			if n == 0 {
+				copy(x, src)
+			} else {
+				AddVecs(x, x, src)
+			}
+		}
+		if l.act != nil {
+			for i, v := range x {
+				x[i] = l.scale * l.act(v*l.omega)
+			}
+		}
+		yk, dyk := ob.layer(m, y, k), ob.layer(m, dy, k)
+		for i, v := range x {
+			dyk[i] = v - l.tau*yk[i]
+		}
+	}
+}
+
+func meanVec(v Vec) float64 {
+	s := 0.0
+	for _, x := range v {
+		s += x
+	}
+	return s / float64(len(v))
+}
+
+func maxAbs(v Vec) float64 {
+	d := 0.0
+	for _, x := range v {
+		d = math.Max(d, math.Abs(x))
+	}
+	return d
+}
+
+// y1 = y + dt * sum_i a[i] * k[i]
+func (ob *odeBuffers) axpy(y1, y Vec, dt float64, a ...float64) {
+	for j := range y1 {
+		s := 0.0
+		for i, ai := range a {
+			if ai != 0 {
+				s += ai * ob.k[i][j]
+			}
+		}
+		y1[j] = y[j] + dt*s
+	}
+}
+
+// Integrates from the state in ob.y; returns the time taken and whether a
+// steady state was reached.
+func (m *Model) integrate(cell *Cell, mats []*Spmat, cue Vec, ob *odeBuffers, rng *rand.Rand) (float64, bool) {
+	tmax := float64(m.MaxDevStep)
+	dt := m.DevDt
+	t := 0.0
+	tnext := 1.0 // Next check of convergence with noise
+	noisy := m.noisyDev()
+	k := &ob.k
+	m.odeDeriv(mats, cue, ob.y, k[0], ob)
+	if !noisy && maxAbs(k[0]) < m.EpsDev {
+		return 0, true
+	}
+
+	for t < tmax {
+		if t+dt > tmax {
+			dt = tmax - t
+		}
+		if m.DevEngine == "rk4" {
+			ob.axpy(ob.tmp, ob.y, dt/2, 1)
+			m.odeDeriv(mats, cue, ob.tmp, k[1], ob)
+			ob.axpy(ob.tmp, ob.y, dt/2, 0, 1)
+			m.odeDeriv(mats, cue, ob.tmp, k[2], ob)
+			ob.axpy(ob.tmp, ob.y, dt, 0, 0, 1)
+			m.odeDeriv(mats, cue, ob.tmp, k[3], ob)
+			ob.axpy(ob.y, ob.y, dt, 1.0/6, 2.0/6, 2.0/6, 1.0/6)
+			t += dt
+			if noisy {
+				for i, l := range m.layers {
+					if l.noisy {
+						m.addDevNoise(ob.layer(m, ob.y, i), dt, rng)
+					}
+				}
+			}
+			m.odeDeriv(mats, cue, ob.y, k[0], ob)
+		} else { // Dormand-Prince 5(4), first same as last
+			ob.axpy(ob.tmp, ob.y, dt, 1.0/5)
+			m.odeDeriv(mats, cue, ob.tmp, k[1], ob)
+			ob.axpy(ob.tmp, ob.y, dt, 3.0/40, 9.0/40)
+			m.odeDeriv(mats, cue, ob.tmp, k[2], ob)
+			ob.axpy(ob.tmp, ob.y, dt, 44.0/45, -56.0/15, 32.0/9)
+			m.odeDeriv(mats, cue, ob.tmp, k[3], ob)
+			ob.axpy(ob.tmp, ob.y, dt, 19372.0/6561, -25360.0/2187, 64448.0/6561, -212.0/729)
+			m.odeDeriv(mats, cue, ob.tmp, k[4], ob)
+			ob.axpy(ob.tmp, ob.y, dt, 9017.0/3168, -355.0/33, 46732.0/5247, 49.0/176, -5103.0/18656)
+			m.odeDeriv(mats, cue, ob.tmp, k[5], ob)
+			ob.axpy(ob.y1, ob.y, dt, 35.0/384, 0, 500.0/1113, 125.0/192, -2187.0/6784, 11.0/84)
+			m.odeDeriv(mats, cue, ob.y1, k[6], ob)
+
+			err := 0.0 // Difference between the 5th and 4th order solutions
+			for j := range ob.y {
+				e := dt * (71.0/57600*k[0][j] - 71.0/16695*k[2][j] + 71.0/1920*k[3][j] -
+					17253.0/339200*k[4][j] + 22.0/525*k[5][j] - 1.0/40*k[6][j])
+				sc := m.DevTol * (1 + math.Max(math.Abs(ob.y[j]), math.Abs(ob.y1[j])))
+				err += (e / sc) * (e / sc)
+			}
+			err = math.Sqrt(err / float64(len(ob.y)))
+			fac := 5.0
+			if err > 0 {
+				fac = math.Min(5, math.Max(0.2, 0.9*math.Pow(err, -0.2)))
+			}
+			if err > 1 {
+				dt *= fac
+				if dt < 1e-10 {
+					return tmax, false // Too stiff
+				}
+				continue
+			}
+			t += dt
+			dt *= fac
+			ob.y, ob.y1 = ob.y1, ob.y
+			k[0], k[6] = k[6], k[0]
+		}
+		if noisy && t >= tnext-1e-9 {
+			tnext++
+			cell.updatePEMA(m, ob.layer(m, ob.y, len(m.layers)-1))
+			if meanVec(cell.Pvar) < m.EpsDev {
+				return t, true
+			}
+		} else if !noisy && maxAbs(k[0]) < m.EpsDev {
+			return t, true
+		}
+	}
+	return tmax, false
+}
+
+func (cell *Cell) devCellODE(m *Model, G Genome, env Cue, rng *rand.Rand, buf *devBuffers) Cell {
+	cb := &buf.cells[0]
+	cue := cell.startDev(m, env, rng, buf, cb)
+	ob := buf.ode
+	for k, l := range m.layers {
+		y := ob.layer(m, ob.y, k)
+		for i := range y {
+			y[i] = l.init
+		}
+	}
+
+	t, steady := m.integrate(cell, genomeMats(&G), cue, ob, rng)
+	cell.NDevStep = int(math.Ceil(t))
+	if !steady {
+		cell.NDevStep = m.MaxDevStep
+	}
+	ip := len(m.layers) - 1
+	for k := range m.layers {
+		copy(cb.x1[k], ob.layer(m, ob.y, k))
+	}
+	if !m.noisyDev() { // Otherwise P is the moving average.
+		copy(cell.P, cb.x1[ip])
+		for i := range cell.Pvar {
+			cell.Pvar[i] = 0
+		}
+	}
+	cell.finishDev(m, env, cb)
+
+	return *cell
+}
@@ -0,0 +1,47 @@
+package multicell
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// The engines develop a genome to the same steady state, also with the leak
+// of layers with Tau < 1.
+func TestDevEnginesAgree(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 1
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	s.TauF = 0.2
+	s.TauG = 0.5
+	s.EpsDev = 1.0e-8
+	s.MaxDevStep = 2000
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+
+	develop := func(engine string) Cell {
+		s1 := s
+		s1.DevEngine = engine
+		m1 := NewModel(s1)
+		indiv := pop.Indivs[0].Develop(m1, pop.AncEnvs, pop.NovEnvs, rand.New(rand.NewSource(3)))
+		cell := indiv.Bodies[INovEnv].Cells[0]
+		if cell.NDevStep >= s.MaxDevStep {
+			t.Fatalf("%s: no steady state", engine)
+		}
+		return cell
+	}
+	want := develop("map")
+	for _, engine := range []string{"rk4", "dopri"} {
+		cell := develop(engine)
+		for i, p := range cell.P {
+			if math.Abs(p-want.P[i]) > 1.0e-5 {
+				t.Errorf("%s: p[%d] = %v, map: %v", engine, i, p, want.P[i])
+				break
+			}
+		}
+	}
+}
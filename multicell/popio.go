@@ -0,0 +1,288 @@
+package multicell
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Reading and writing populations (and checkpoints) as gzipped JSON.
+/*
+   Load and Save work on streams and return errors instead of terminating
+   the program, so that a driver can skip a corrupt file and carry on.
+   Errors are one of
+     ErrTruncated    the gzip stream ended prematurely (e.g., killed writer)
+     *SchemaError    the data is not a JSON encoding of the expected type
+     *DimensionError the population does not match its own Settings
+     *VersionError   the file format is too new or too old (see migrate.go)
+   or an error from the underlying reader/writer (or of invalid Settings).
+*/
+
+var ErrTruncated = errors.New("truncated gzip stream")
+
+type SchemaError struct {
+	Err error // Error from encoding/json
+}
+
+func (e *SchemaError) Error() string {
+	return "JSON schema mismatch: " + e.Err.Error()
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+type DimensionError struct {
+	What      string // Which vector or matrix
+	Got, Want int
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("dimension mismatch in %s: got %d, want %d", e.What, e.Got, e.Want)
+}
+
+func readError(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ErrTruncated
+	}
+	return err
+}
+
+func decodeError(err error) error {
+	var serr *json.SyntaxError
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &serr) || errors.As(err, &terr) {
+		return &SchemaError{err}
+	}
+	return readError(err)
+}
+
+// Decodes gzipped JSON from r into v.
+func loadGzJSON(r io.Reader, v interface{}) error {
+	gzreader, err := gzip.NewReader(r)
+	if err != nil {
+		return readError(err)
+	}
+	err = json.NewDecoder(gzreader).Decode(v)
+	if err != nil {
+		return decodeError(err)
+	}
+	// Read to the end so that the gzip trailer (checksum and length) is verified.
+	_, err = io.Copy(io.Discard, gzreader)
+	if err != nil {
+		return readError(err)
+	}
+
+	return gzreader.Close()
+}
+
+// Encodes v as gzipped JSON to w.
+func saveGzJSON(w io.Writer, v interface{}, level int) error {
+	zipper, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(zipper).Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return zipper.Close() //Close gzipper and flush compressed info
+}
+
+// Writes to a temporary file first so that a crash never leaves a broken file.
+func saveFile(filename string, save func(io.Writer) error) error {
+	tmpname := filename + ".tmp"
+	fout, err := os.OpenFile(tmpname, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = save(fout)
+	if err != nil {
+		fout.Close()
+		os.Remove(tmpname)
+		return err
+	}
+	err = fout.Close()
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+
+	return os.Rename(tmpname, filename)
+}
+
+func loadFile(filename string, load func(io.Reader) error) error {
+	fin, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	err = load(fin)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// Load reads a gzipped JSON encoding of population from r; pop is replaced
+// (fields absent from the file are zero) only if it is valid.
+func (pop *Population) Load(r io.Reader) error {
+	var pop1 Population
+	err := loadGzJSON(r, &pop1)
+	if err != nil {
+		return err
+	}
+	err = pop1.checkVersion()
+	if err != nil {
+		return err
+	}
+	pop1.upgrade()
+	err = pop1.Validate()
+	if err != nil {
+		return err
+	}
+	*pop = pop1
+	return nil
+}
+
+// Save writes a gzipped JSON encoding of population to w.
+func (pop *Population) Save(w io.Writer) error {
+	pop1 := *pop.stored()
+	pop1.FormatVersion = PopFormatVersion
+	return saveGzJSON(w, &pop1, gzip.BestCompression)
+}
+
+func (pop *Population) ImportPopGz(filename string) error {
+	err := loadFile(filename, pop.Load)
+	if err != nil {
+		return err
+	}
+	log.Println("Successfully imported population from", filename)
+	return nil
+}
+
+func (pop *Population) ExportPopGz(filename string) error { //Exports population to .json.gz file
+	err := saveFile(filename, pop.Save)
+	if err != nil {
+		return err
+	}
+	log.Println("Successfully exported population to", filename)
+	return nil
+}
+
+func checkDim(what string, got, want int) error {
+	if got != want {
+		return &DimensionError{what, got, want}
+	}
+	return nil
+}
+
+func checkSpmat(what string, sp *Spmat, nrow, ncol int) error {
+	if err := checkDim(what+" rows", sp.NRow(), nrow); err != nil {
+		return err
+	}
+	if err := checkDim(what+" columns", sp.Ncol, ncol); err != nil {
+		return err
+	}
+	if err := sp.check(); err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	return nil
+}
+
+type stateDim struct {
+	name string
+	v    Vec
+	n    int
+}
+
+// Validate checks the dimensions of the population against its Settings.
+func (pop *Population) Validate() error {
+	s := pop.Params
+	if err := s.Check(); err != nil {
+		return fmt.Errorf("Settings: %w", err)
+	}
+	if err := checkDim("AncEnvs", len(pop.AncEnvs), s.NCells); err != nil {
+		return err
+	}
+	if err := checkDim("NovEnvs", len(pop.NovEnvs), s.NCells); err != nil {
+		return err
+	}
+	for i, env := range pop.AncEnvs {
+		if err := checkDim(fmt.Sprintf("AncEnvs[%d]", i), len(env), s.NEnv); err != nil {
+			return err
+		}
+	}
+	for i, env := range pop.NovEnvs {
+		if err := checkDim(fmt.Sprintf("NovEnvs[%d]", i), len(env), s.NEnv); err != nil {
+			return err
+		}
+	}
+	if len(pop.Envs) > 0 {
+		if err := checkDim("Envs", len(pop.Envs), s.NEnvs-NBodies); err != nil {
+			return err
+		}
+	}
+	for k, envs := range pop.Envs {
+		for i, env := range envs {
+			if err := checkDim(fmt.Sprintf("Envs[%d][%d]", k, i), len(env), s.NEnv); err != nil {
+				return err
+			}
+		}
+	}
+	for k, indiv := range pop.Indivs {
+		if err := checkDim(fmt.Sprintf("Indivs[%d].Bodies", k), len(indiv.Bodies), s.NEnvs); err != nil {
+			return err
+		}
+		if indiv.Dp != nil {
+			if err := checkDim(fmt.Sprintf("Indivs[%d].Dp", k), len(indiv.Dp), s.NEnvs); err != nil {
+				return err
+			}
+		}
+		for b, body := range indiv.Bodies {
+			what := fmt.Sprintf("Indivs[%d].Bodies[%d]", k, b)
+			if err := checkDim(what+".Cells", len(body.Cells), s.NCells); err != nil {
+				return err
+			}
+			for _, cell := range body.Cells {
+				vecs := []stateDim{{"E", cell.E, s.NEnv}, {"F", cell.F, s.NGenes}, {"G", cell.G, s.NGenes},
+					{"H", cell.H, s.NGenes}, {"P", cell.P, s.NEnv}}
+				for _, l := range s.Layers {
+					if !isCellLayer(l.Name) {
+						vecs = append(vecs, stateDim{"X." + l.Name, cell.X[l.Name], s.layerSize(l)})
+					}
+				}
+				if s.Lattice > 0 {
+					vecs = append(vecs, stateDim{"X.s", cell.X["s"], s.signalSize()})
+				}
+				for _, t := range vecs {
+					if err := checkDim(what+".Cells."+t.name, len(t.v), t.n); err != nil {
+						return err
+					}
+				}
+			}
+			G := body.Genome
+			if G == nil {
+				return fmt.Errorf("%s: no Genome", what)
+			}
+			dims := s.matrixDims()
+			if err := checkDim(what+".Genome.Extra", len(G.Extra), len(dims)-nGenomeMats); err != nil {
+				return err
+			}
+			for i, sp := range genomeMats(G) {
+				if err := checkSpmat(what+".Genome."+dims[i].name, sp, dims[i].nrow, dims[i].ncol); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package multicell
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func savedPopulation(t *testing.T, pop *Population) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := pop.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPopulationLoad(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+	data := savedPopulation(t, &pop)
+
+	// Fields absent from the file must not survive Load.
+	stale := pop.Copy()
+	stale.Envs = []Cues{CopyCues(pop.NovEnvs)}
+	stale.Provenance = &Provenance{}
+
+	wrongDim := pop.Copy()
+	wrongDim.Params.NEnv++
+	truncatedCues := pop.Copy()
+	truncatedCues.NovEnvs = truncatedCues.NovEnvs[:0]
+	var schema bytes.Buffer
+	if err := saveGzJSON(&schema, map[string]int{"Indivs": 3}, gzip.BestSpeed); err != nil {
+		t.Fatal(err)
+	}
+
+	var errSchema *SchemaError
+	var errDim *DimensionError
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(err error) bool
+	}{
+		{"round trip", data, func(err error) bool { return err == nil }},
+		{"truncated", data[:len(data)/2], func(err error) bool { return errors.Is(err, ErrTruncated) }},
+		{"schema", schema.Bytes(), func(err error) bool { return errors.As(err, &errSchema) }},
+		{"dimension", savedPopulation(t, &wrongDim), func(err error) bool { return errors.As(err, &errDim) }},
+		{"truncated cues", savedPopulation(t, &truncatedCues), func(err error) bool { return errors.As(err, &errDim) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pop1 := stale.Copy()
+			err := pop1.Load(bytes.NewReader(tt.data))
+			if !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			switch {
+			case err == nil && !reflect.DeepEqual(pop1, pop):
+				t.Error("loaded population differs from saved one")
+			case err != nil && !reflect.DeepEqual(pop1, stale):
+				t.Error("population changed by a failed Load")
+			}
+		})
+	}
+}
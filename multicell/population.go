@@ -1,25 +1,24 @@
 package multicell
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
 	"math"
 	"math/rand"
-	"os"
+	"runtime"
 	"sort"
+	"sync"
 	//	"gonum.org/v1/gonum/mat"
 )
 
 type Population struct { //Population of individuals
-	Params  Settings
-	Gen     int
-	NovEnvs Cues //Novel Environment
-	AncEnvs Cues // Ancestral Environment
-	Indivs  []Indiv
+	FormatVersion int // See migrate.go
+	Params        Settings
+	Epoch         int
+	Gen           int
+	NovEnvs       Cues   //Novel Environment
+	AncEnvs       Cues   // Ancestral Environment
+	Envs          []Cues `json:",omitempty"` // Further environments (NEnvs > 2; see envs.go)
+	Indivs        []Indiv
+	Provenance    *Provenance `json:",omitempty"` // How the population was produced
 }
 
 type PopStats struct { // Statistics of population (mean values of quantities of interest)
@@ -33,9 +32,11 @@ type PopStats struct { // Statistics of population (mean values of quantities of
 	Plasticity float64
 	Div        float64
 	NDevStep   float64
+	BodyFit    []float64   // Fitness in each environment (NEnvs > 2)
+	PEDiff     [][]float64 // <|| p(ek) - el ||> (NEnvs > 2)
 }
 
-func (pop *Population) GetStats() PopStats {
+func (pop *Population) GetStats(m *Model) PopStats {
 	var stats PopStats
 	mf := 0.0
 	maxfit := 0.0
@@ -46,8 +47,8 @@ func (pop *Population) GetStats() PopStats {
 	ndev := 0
 	mop := 0.0 // mean observed plasticity
 	fn := float64(len(pop.Indivs))
-	pa := NewCues(ncells, nenv)
-	pv := NewCues(ncells, nenv)
+	pa := NewCues(m.NCells, m.NEnv)
+	pv := NewCues(m.NCells, m.NEnv)
 
 	denv := 0.0
 	for i, env := range pop.NovEnvs { //To normalize wrt change in environment cue
@@ -95,14 +96,14 @@ func (pop *Population) GetStats() PopStats {
 		}
 	}
 
-	env0 := FlattenEnvs(GetSelEnvs(pop.AncEnvs))
-	env1 := FlattenEnvs(GetSelEnvs(pop.NovEnvs))
+	env0 := FlattenEnvs(GetSelEnvs(m, pop.AncEnvs))
+	env1 := FlattenEnvs(GetSelEnvs(m, pop.NovEnvs))
 	lenP := len(env1)
 	dirE := NewVec(lenP)
 	DiffVecs(dirE, env1, env0)
 	NormalizeVec(dirE)
 
-	mp1 := GetMeanVec(pop.GetFlatStateVec("P", 1, 0, nsel))
+	mp1 := GetMeanVec(pop.GetFlatStateVec("P", 1, 0, m.NSel))
 	dirP := NewVec(lenP)
 	DiffVecs(dirP, mp1, env0)
 	NormalizeVec(dirP)
@@ -114,102 +115,38 @@ func (pop *Population) GetStats() PopStats {
 	stats.PED01 = md01 / fn
 	meanfit := mf / fn
 	stats.Fitness = meanfit
-	stats.WagFit = meanfit / maxfit
+	stats.WagFit = 1.0 //None converged; as SetWagnerFitness
+	if maxfit > 0 {
+		stats.WagFit = meanfit / maxfit
+	}
 	stats.NDevStep = float64(ndev) / fn
 	stats.Plasticity = mop / (fn * denv)
 	stats.Div = div
+	pop.getEnvStats(m, &stats)
 
 	return stats
 }
 
-func NewPopulation(s Settings) Population {
-	SetParams(s)
-
-	envs0 := NewCues(s.NCells, s.NEnv)
-	envs1 := NewCues(s.NCells, s.NEnv)
+func NewPopulation(m *Model) Population {
+	envs0 := NewCues(m.NCells, m.NEnv)
+	envs1 := NewCues(m.NCells, m.NEnv)
+	var envs []Cues
+	for k := NBodies; k < m.NEnvs; k++ {
+		envs = append(envs, NewCues(m.NCells, m.NEnv))
+	}
 
-	indivs := make([]Indiv, s.MaxPop)
+	indivs := make([]Indiv, m.MaxPop)
 	for i := range indivs {
-		indivs[i] = NewIndiv(i)
+		indivs[i] = NewIndiv(m, i)
 	}
 
-	p := Population{Params: s, Gen: 0, AncEnvs: envs0, NovEnvs: envs1,
-		Indivs: indivs}
+	p := Population{FormatVersion: PopFormatVersion, Params: m.Settings, Gen: 0, AncEnvs: envs0, NovEnvs: envs1,
+		Envs: envs, Indivs: indivs}
 	return p
 }
 
-func (pop *Population) ImportPopGz(filename string) {
-	pop.ClearGenome()
-	fin, err := os.Open(filename) //This should be a .json.gz file
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//byteValue, _ := io.ReadAll(fin)
-	gzreader, err := gzip.NewReader(fin)
-	buf := new(bytes.Buffer)
-	io.Copy(buf, gzreader)
-	err = json.Unmarshal(buf.Bytes(), pop)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = fin.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Println("Successfully imported population from", filename)
-}
-
-func (pop *Population) ExportPopGz(filename string) { //Exports population to .json.gz file
-	jsonpop, err := json.Marshal(pop) //JSON encoding of population as byte array
-	if err != nil {
-		log.Fatal(err)
-	}
-	fout, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644) //create json.gz file
-	if err != nil {
-		log.Fatal(err)
-	}
-	/* Writing directly to .json file, bugtest
-	utest, err := os.OpenFile("utest.json", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	_, err = utest.Write(jsonpop)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = utest.Close()
-	if err != nil {
-		log.Fatal(err)
-	}
-	//*/
-
-	//log.Println("Successfully exported population to", filename)
-
-	var buf bytes.Buffer
-	zipper, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = zipper.Write([]byte(string(jsonpop)))
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = zipper.Close() //Close gzipper and flush compressed info
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = os.WriteFile(filename, []byte(buf.String()), 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = fout.Close() //Close file
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Println("Successfully exported population to", filename)
-}
-
 func (pop *Population) SetWagnerFitness() { //compute normalized fitness value similar to Wagner (1996).
+	minfit := pop.Params.MinWagnerFitness
 	var mf float64
 	for _, indiv := range pop.Indivs {
 		if mf < indiv.Fit {
@@ -217,16 +154,27 @@ func (pop *Population) SetWagnerFitness() { //compute normalized fitness value s
 		}
 	}
 	for i, indiv := range pop.Indivs {
-		pop.Indivs[i].WagFit = math.Max(indiv.Fit/mf, minWagnerFitness) //Zero fitness individuals that don't converge can still reproduce
+		if mf == 0 { //None converged; all equal
+			pop.Indivs[i].WagFit = 1.0
+			continue
+		}
+		pop.Indivs[i].WagFit = math.Max(indiv.Fit/mf, minfit) //Zero fitness individuals that don't converge can still reproduce
 	}
 }
 
-func (pop *Population) RandomizeGenome() {
+func (pop *Population) RandomizeGenome(m *Model) {
 	for _, indiv := range pop.Indivs { //Sets genome of every individual to
-
-		indiv.Bodies[0].Genome.Randomize()
-		indiv.Bodies[1].Genome = indiv.Bodies[0].Genome.Copy()
-		indiv.Bodies[1].Genome.Mutate()
+		rng := m.NewStream(StreamInit, pop.Epoch, pop.Gen, indiv.Id)
+		indiv.Bodies[0].Genome.Randomize(m, rng)
+		for k := 1; k < len(indiv.Bodies); k++ {
+			if m.SharedGenome {
+				indiv.Bodies[k].Genome = indiv.Bodies[0].Genome
+				continue
+			}
+			genome := indiv.Bodies[0].Genome.Copy()
+			genome.Mutate(m, rng)
+			indiv.Bodies[k].Genome = &genome
+		}
 	}
 }
 
@@ -239,21 +187,28 @@ func (pop *Population) ClearGenome() {
 }
 
 func (pop *Population) Copy() Population {
-	pop1 := NewPopulation(pop.Params)
+	var pop1 Population
+	pop1.FormatVersion = pop.FormatVersion
 	pop1.Params = pop.Params
+	pop1.Epoch = pop.Epoch
 	pop1.Gen = pop.Gen
 	pop1.NovEnvs = CopyCues(pop.NovEnvs)
 	pop1.AncEnvs = CopyCues(pop.AncEnvs)
+	for _, env := range pop.Envs {
+		pop1.Envs = append(pop1.Envs, CopyCues(env))
+	}
+	pop1.Indivs = make([]Indiv, len(pop.Indivs))
 	for i, indiv := range pop.Indivs {
 		pop1.Indivs[i] = indiv.Copy()
 	}
+	pop1.Provenance = pop.Provenance
 	return pop1
 }
 
-func (pop *Population) GetMeanPhenotype(gen int) Cues { //elementwise average phenotype of population; output as slice instead of cue struct
+func (pop *Population) GetMeanPhenotype(m *Model, gen int) Cues { //elementwise average phenotype of population; output as slice instead of cue struct
 	npop := len(pop.Indivs)
-	MeanPhenotype := NewCues(ncells, nenv)
-	pop.DevPop(gen)
+	MeanPhenotype := NewCues(m.NCells, m.NEnv)
+	pop.DevPop(m, gen)
 
 	for _, indiv := range pop.Indivs {
 		for i, c := range indiv.Bodies[INovEnv].Cells {
@@ -265,7 +220,7 @@ func (pop *Population) GetMeanPhenotype(gen int) Cues { //elementwise average ph
 	return MeanPhenotype
 }
 
-func (pop *Population) Selection(nNewPop int) []Indiv { //Selects parents for new population
+func (pop *Population) Selection(nNewPop int, rng *rand.Rand) []Indiv { //Selects parents for new population
 	npop := len(pop.Indivs)
 	//var parents []Indiv //Does this even work?
 	parents := make([]Indiv, 0)
@@ -273,63 +228,46 @@ func (pop *Population) Selection(nNewPop int) []Indiv { //Selects parents for ne
 	cnt := 0
 	for ipop < nNewPop && cnt < 1000*nNewPop {
 		cnt += 1
-		k := rand.Intn(npop)
+		k := rng.Intn(npop)
 		ind := pop.Indivs[k]
-		r := rand.Float64()
+		r := rng.Float64()
 		if r < ind.WagFit {
 			parents = append(parents, ind)
 			ipop += 1
 		}
 	}
+	for ipop < nNewPop { //Rejection failed (e.g., NaN fitness); the rest uniformly
+		parents = append(parents, pop.Indivs[rng.Intn(npop)])
+		ipop += 1
+	}
 	return parents
 }
 
-func (pop *Population) Reproduce(nNewPop int) Population { //Crossover
-	parents := pop.Selection(nNewPop)
+func (pop *Population) PairReproduce(m *Model, nNewPop int) Population { //Crossover in ordered pairs; as in Wagner's
 	nindivs := make([]Indiv, 0)
-	npop := len(parents)
-
-	for len(nindivs) < nNewPop { //Randomly reproduce among survivors
-		k := rand.Intn(npop)
-		l := rand.Intn(npop)
-		dad := parents[k]
-		mom := parents[l]
-		kid0, kid1 := Mate(&dad, &mom)
-		nindivs = append(nindivs, kid0)
-		nindivs = append(nindivs, kid1)
-		//ipop += 2
-	}
-
-	for i := range nindivs {
-		nindivs[i].Id = i //Relabels individuals according to position in array
+	if m.Elite > 0 { //Fittest copied unchanged
+		for _, k := range pop.ranked()[:m.Elite] {
+			nindivs = append(nindivs, cloneIndiv(m, &pop.Indivs[k]))
+		}
 	}
+	nkids := nNewPop - len(nindivs)
+	parents := m.selector.Parents(m, pop, nkids+nkids%2, m.NewStream(StreamSelect, pop.Epoch, pop.Gen))
 
-	new_population := Population{pop.Params, 0, pop.NovEnvs, pop.AncEnvs, nindivs} //resets embryonic values to zero!
-
-	return new_population
-
-}
-
-func (pop *Population) PairReproduce(nNewPop int) Population { //Crossover in ordered pairs; as in Wagner's
-	var index int
-	parents := pop.Selection(nNewPop)
-	//nparents := len(parents)
-	nindivs := make([]Indiv, 0)
-
-	for index < nNewPop && len(nindivs) < nNewPop { //Forced reproduction in ordered pairs; may cause bugs when population has an odd number of survivors
+	for index := 0; index+1 < len(parents); index += 2 { //Forced reproduction in ordered pairs
 		dad := parents[index]
 		mom := parents[index+1]
-		kid0, kid1 := Mate(&dad, &mom)
+		mrng := m.NewStream(StreamMate, pop.Epoch, pop.Gen, index/2)
+		kid0, kid1 := Mate(m, &dad, &mom, mrng)
 		nindivs = append(nindivs, kid0)
 		nindivs = append(nindivs, kid1)
-		index = len(nindivs) //update
 	}
+	nindivs = nindivs[:nNewPop] //One kid too many with odd nkids
 
 	for i := range nindivs {
 		nindivs[i].Id = i //Relabels individuals according to position in array
 	}
 
-	new_population := Population{pop.Params, 0, pop.NovEnvs, pop.AncEnvs, nindivs} //resets embryonic values to zero!
+	new_population := Population{PopFormatVersion, pop.Params, pop.Epoch, 0, pop.NovEnvs, pop.AncEnvs, pop.Envs, nindivs, pop.Provenance} //resets embryonic values to zero!
 
 	return new_population
 }
@@ -338,28 +276,57 @@ func (pop *Population) SortPopIndivs() {
 	sort.Slice(pop.Indivs, func(i, j int) bool { return pop.Indivs[i].Id < pop.Indivs[j].Id })
 }
 
-func (pop *Population) ChangeEnvs(denv int) {
+func (pop *Population) ChangeEnvs(m *Model, denv int) {
 	OldEnvs := CopyCues(pop.NovEnvs)
 	pop.AncEnvs = OldEnvs
-	pop.NovEnvs = ChangeEnvs(OldEnvs, denv)
+	pop.NovEnvs = ChangeEnvs(m, OldEnvs, denv)
+	pop.addEnvs(m)
+	for i, env := range pop.Envs {
+		pop.Envs[i] = ChangeEnvs(m, env, denv)
+	}
 }
 
-func (pop *Population) SetRandomNovEnvs() {
-	pop.NovEnvs = RandomEnvs(ncells, 0.5)
+func (pop *Population) SetRandomNovEnvs(m *Model) {
+	pop.NovEnvs = RandomEnvs(m, 0.5)
+	pop.Envs = nil
+	pop.addEnvs(m)
 }
 
-func (pop *Population) DevPop(gen int) Population {
+func (pop *Population) DevPop(m *Model, gen int) Population {
+	return pop.devPop(m, gen, m.run.seed)
+}
+
+// Development with the random number streams of the run with the given seed.
+func (pop *Population) devPop(m *Model, gen int, seed int64) Population {
 	pop.Gen = gen
 
-	ch := make(chan Indiv) //channels for parallelization
-	for _, indiv := range pop.Indivs {
-		go func(indiv Indiv) {
-			ch <- indiv.Develop(pop.AncEnvs, pop.NovEnvs)
-		}(indiv)
+	// Pool of GOMAXPROCS workers, each with its own work vectors.
+	nworker := runtime.GOMAXPROCS(0)
+	if nworker > len(pop.Indivs) {
+		nworker = len(pop.Indivs)
 	}
+	jobs := make(chan int, len(pop.Indivs))
 	for i := range pop.Indivs {
-		pop.Indivs[i] = <-ch //Update output results
+		jobs <- i
+	}
+	close(jobs)
+
+	envs := pop.envList()
+	var wg sync.WaitGroup
+	for w := 0; w < nworker; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := newDevBuffers(m)
+			rng := newStream(seed)
+			for i := range jobs {
+				indiv := &pop.Indivs[i]
+				rng.Seed(streamSeed(seed, StreamDev, pop.Epoch, gen, indiv.Id)) //independent of scheduling
+				indiv.develop(m, envs, rng, buf)
+			}
+		}()
 	}
+	wg.Wait()
 
 	pop.SetWagnerFitness()
 	//We might need a sorter here.
@@ -368,31 +335,35 @@ func (pop *Population) DevPop(gen int) Population {
 	return *pop
 }
 
-//Records population trajectory and writes files
-func (pop0 *Population) Evolve(test bool, ftraj *os.File, jsonout string, nstep, epoch int) Population {
+//Evolves population for nstep generations; observers record the trajectory and write files
+func (pop0 *Population) Evolve(m *Model, nstep, epoch int, observers ...Observer) (Population, error) {
 	pop := *pop0
+	pop.Epoch = epoch
 
-	fmt.Fprintln(ftraj, "#Epoch\tGen\tNpop\tPhenoEnvDot \tMeanErr1 \tMeanErr0 \tMeanDp1e0 \tMeanDp0e1 \tFitness \tWag_Fit \tObs_Plas \tDiversity \tNdev") //header
+	for _, obs := range observers {
+		if err := obs.BeginEpoch(m, &pop); err != nil {
+			return pop, err
+		}
+	}
 
 	for istep := 1; istep <= nstep; istep++ {
-		pop.DevPop(istep)
-		if test {
-			if jsonout != "" { //Export .json.gz population of each generation in test mode
-				filename := fmt.Sprintf("%s_%2.2d_%3.3d.json.gz", jsonout, epoch, pop.Gen)
-				pop.ExportPopGz(filename)
+		pop.DevPop(m, istep)
+		pstat := pop.GetStats(m)
+		for _, obs := range observers {
+			if err := obs.Observe(m, &pop, pstat); err != nil {
+				return pop, err
 			}
 		}
 
-		pstat := pop.GetStats()
-		popsize := len(pop.Indivs)
-
-		fmt.Fprintf(ftraj, "%d\t%d\t%d\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e\t%e\n", epoch, istep, popsize, pstat.PEDot, pstat.PErr1, pstat.PErr0, pstat.PED10, pstat.PED01, pstat.Fitness, pstat.WagFit, pstat.Plasticity, pstat.Div, pstat.NDevStep)
-
-		fmt.Printf("Evolve: %d\t<ME1>: %e\t<ME0>: %e\t DevStep: %e", istep, pstat.PErr1, pstat.PErr0, pstat.NDevStep)
+		pop = pop.reproduce(m)
+	}
 
-		pop = pop.PairReproduce(maxPop)
+	for _, obs := range observers {
+		if err := obs.EndEpoch(m, &pop); err != nil {
+			return pop, err
+		}
 	}
-	return pop
+	return pop, nil
 }
 
 func (pop *Population) GetFlatStateVec(istate string, ienv, ibeg, iend int) Dmat {
@@ -408,10 +379,10 @@ func (pop *Population) GetFlatStateVec(istate string, ienv, ibeg, iend int) Dmat
 	return vs0
 }
 
-func (pop *Population) GetFlatGenome(IEnv int) Dmat {
+func (pop *Population) GetFlatGenome(m *Model, IEnv int) Dmat {
 	vs := make([]Vec, 0)
 	for _, indiv := range pop.Indivs {
-		tv := indiv.Bodies[IEnv].Genome.FlatVec()
+		tv := indiv.Bodies[IEnv].Genome.FlatVec(m)
 		vs = append(vs, tv)
 	}
 	return vs
@@ -0,0 +1,63 @@
+package multicell
+
+import (
+	"fmt"
+)
+
+// Standard layer architectures.
+/*
+   A preset is named after the layers of the model: E (environmental cue),
+   F, G, H, J, and P (phenotype feedback to the input), with "_" for an
+   absent layer; e.g., EFGHJP (full model) or E_G__P. G is always present.
+   The number of genes and the densities of the named models below are
+   chosen so that the number of inputs per gene is comparable to EFGHJP;
+   other combinations use the default number of genes and densities.
+*/
+
+var Presets = []string{"EFGHJP", "E_G__P", "EFGH__", "__G___"}
+
+type presetSize struct {
+	ngenes                                 int
+	densityE, densityG, densityH, densityP float64
+}
+
+var presetSizes = map[string]presetSize{
+	"E_G__P": {600, defaultDensity / 3, 4 * defaultDensity / 9, 0, defaultDensity / 3},  // Single fat layer [NoHier]
+	"EFGH__": {200, defaultDensity, defaultDensity, 2 * defaultDensity, defaultDensity}, // Feedforward deep model [NoDev]
+	"__G___": {800, 0, 5 * defaultDensity / 16, 0, defaultDensity / 4},                  // Single fat feedforward layer [Null]
+}
+
+// SetPreset sets the layers, number of genes and densities of a preset;
+// other settings are unchanged.
+func (s *Settings) SetPreset(name string) error {
+	const layers = "EFGHJP"
+	if len(name) != len(layers) {
+		return fmt.Errorf("preset %q: want %d letters of %s or _", name, len(layers), layers)
+	}
+	for i := range name {
+		if name[i] != layers[i] && (name[i] != '_' || layers[i] == 'G') {
+			return fmt.Errorf("preset %q: position %d must be %c or _ (G is always present)", name, i+1, layers[i])
+		}
+	}
+
+	s.WithCue = name[0] == 'E'
+	s.FLayer = name[1] == 'F'
+	s.HLayer = name[3] == 'H'
+	s.JLayer = name[4] == 'J'
+	s.Pfback = name[5] == 'P'
+	s.Layers = nil
+
+	size, ok := presetSizes[name]
+	if !ok {
+		size = presetSize{200, defaultDensity, defaultDensity, defaultDensity, defaultDensity}
+	}
+	s.NGenes = size.ngenes
+	s.DensityE = size.densityE
+	s.DensityF = defaultDensity
+	s.DensityG = size.densityG
+	s.DensityH = size.densityH
+	s.DensityJ = defaultDensity
+	s.DensityP = size.densityP
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package multicell
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// Provenance records how a population was produced.
+type Provenance struct {
+	Args         []string          // Command line
+	Flags        map[string]string `json:",omitempty"` // Values of all flags after resolving config files
+	Seed         int64
+	SeedCue      int64
+	Module       string      `json:",omitempty"` // Module path and version
+	Revision     string      `json:",omitempty"` // VCS revision; "+dirty" if modified
+	GoVersion    string      `json:",omitempty"`
+	Parent       string      `json:",omitempty"` // Input population file
+	ParentSHA256 string      `json:",omitempty"` // Hash of Parent
+	ParentProv   *Provenance `json:",omitempty"` // Provenance of Parent
+}
+
+// NewProvenance fills in the command line and build information of the
+// running program.
+func NewProvenance(seed, seedCue int64, flags map[string]string) *Provenance {
+	prov := &Provenance{Args: os.Args, Flags: flags, Seed: seed, SeedCue: seedCue}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		prov.Module = bi.Main.Path + "@" + bi.Main.Version
+		prov.GoVersion = bi.GoVersion
+		dirty := ""
+		for _, kv := range bi.Settings {
+			switch kv.Key {
+			case "vcs.revision":
+				prov.Revision = kv.Value
+			case "vcs.modified":
+				if kv.Value == "true" {
+					dirty = "+dirty"
+				}
+			}
+		}
+		if prov.Revision != "" {
+			prov.Revision += dirty
+		}
+	}
+	return prov
+}
+
+// SetParent records the input population file and its provenance.
+func (prov *Provenance) SetParent(filename string, parent *Population) error {
+	fin, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, fin)
+	if err != nil {
+		return err
+	}
+	prov.Parent = filename
+	prov.ParentSHA256 = hex.EncodeToString(h.Sum(nil))
+	prov.ParentProv = parent.Provenance
+
+	return nil
+}
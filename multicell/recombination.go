@@ -0,0 +1,167 @@
+package multicell
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Recombination of the parental genomes in Mate.
+/*
+   The loci are the NGenes genes in order. Gene i has row i of E ... J (its
+   inputs) and column i of P (its effect on the traits); an Extra matrix
+   has the genes as rows if it has NGenes rows, otherwise as columns if it
+   has NGenes columns, otherwise it is inherited whole with the first locus.
+   The two offspring of a pair get complementary genomes, by Recombination:
+     free     each row of each matrix from either parent with probability
+              1/2 (default, as before)
+     clonal   no recombination: each offspring copies one parent (asexual)
+     kpoint   CrossPoints crossovers at random positions along the loci
+     linkage  a crossover between loci i and i+1 with probability
+              LinkageMap[i] (the linkage map; empty: RecombRate for all)
+     uniform  each element (weight) from either parent with probability 1/2
+   With kpoint and linkage, a gene comes from the same parent in all
+   matrices, and the first gene from either parent with probability 1/2;
+   linkage with rate 1/2 is free recombination of whole loci. A mating
+   recombines with probability CrossRate (1 by default), otherwise the
+   offspring are clonal. Frozen matrices are never recombined.
+*/
+
+type Recombiner interface {
+	// Recombines the non-frozen matrices of the genomes of the two offspring.
+	Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand)
+}
+
+type freeRecombiner struct{}
+
+func (freeRecombiner) Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand) {
+	for i := range mats0 {
+		if !m.frozen[i] {
+			CrossoverSpmats(mats0[i], mats1[i], rng)
+		}
+	}
+}
+
+type clonalRecombiner struct{}
+
+func (clonalRecombiner) Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand) {}
+
+type kpointRecombiner struct{}
+
+func (kpointRecombiner) Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand) {
+	nloci := m.NGenes
+	points := rng.Perm(nloci - 1)[:m.CrossPoints]
+	sort.Ints(points)
+	swap := make([]bool, nloci)
+	side := rng.Float64() < 0.5
+	for i := range swap {
+		if len(points) > 0 && points[0] == i-1 {
+			side = !side
+			points = points[1:]
+		}
+		swap[i] = side
+	}
+	swapLoci(m, mats0, mats1, swap)
+}
+
+type linkageRecombiner struct{}
+
+func (linkageRecombiner) Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand) {
+	swap := make([]bool, m.NGenes)
+	side := rng.Float64() < 0.5
+	for i := range swap {
+		if i > 0 {
+			r := m.RecombRate
+			if len(m.LinkageMap) > 0 {
+				r = m.LinkageMap[i-1]
+			}
+			if rng.Float64() < r {
+				side = !side
+			}
+		}
+		swap[i] = side
+	}
+	swapLoci(m, mats0, mats1, swap)
+}
+
+type uniformRecombiner struct{}
+
+func (uniformRecombiner) Recombine(m *Model, mats0, mats1 []*Spmat, rng *rand.Rand) {
+	for i := range mats0 {
+		if !m.frozen[i] {
+			CrossoverElements(mats0[i], mats1[i], rng)
+		}
+	}
+}
+
+var recombiners = map[string]Recombiner{
+	"free":    freeRecombiner{},
+	"clonal":  clonalRecombiner{},
+	"kpoint":  kpointRecombiner{},
+	"linkage": linkageRecombiner{},
+	"uniform": uniformRecombiner{},
+}
+
+func RecombinationNames() []string {
+	names := make([]string, 0, len(recombiners))
+	for name := range recombiners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Settings) checkRecombination() error {
+	nloci := s.NGenes
+	if _, ok := recombiners[s.Recombination]; !ok {
+		return fmt.Errorf("unknown recombination %q (known: %v)", s.Recombination, RecombinationNames())
+	}
+	switch {
+	case s.CrossRate < 0 || s.CrossRate > 1:
+		return fmt.Errorf("CrossRate must be in [0, 1]")
+	case s.Recombination == "kpoint" && (s.CrossPoints < 1 || s.CrossPoints >= nloci):
+		return fmt.Errorf("CrossPoints must be in [1, %d)", nloci)
+	case s.RecombRate < 0 || s.RecombRate > 1:
+		return fmt.Errorf("RecombRate must be in [0, 1]")
+	case len(s.LinkageMap) > 0 && len(s.LinkageMap) != nloci-1:
+		return fmt.Errorf("LinkageMap: %d values for %d loci (need %d)", len(s.LinkageMap), nloci, nloci-1)
+	}
+	for _, r := range s.LinkageMap {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("LinkageMap values must be in [0, 1]")
+		}
+	}
+	return nil
+}
+
+// Recombines the genomes of the two offspring of a mating.
+func (m *Model) recombine(mats0, mats1 []*Spmat, rng *rand.Rand) {
+	if m.CrossRate < 1 && rng.Float64() >= m.CrossRate {
+		return
+	}
+	m.recombiner.Recombine(m, mats0, mats1, rng)
+}
+
+// Swaps the genes i with swap[i] between the non-frozen matrices.
+func swapLoci(m *Model, mats0, mats1 []*Spmat, swap []bool) {
+	for i := range mats0 {
+		mat0, mat1 := mats0[i], mats1[i]
+		switch {
+		case m.frozen[i]:
+		case genesAsRows(i, mat0, len(swap)):
+			SwapRows(mat0, mat1, swap)
+		case mat0.Ncol == len(swap):
+			SwapCols(mat0, mat1, swap)
+		case swap[0]:
+			*mat0, *mat1 = *mat1, *mat0
+		}
+	}
+}
+
+// Whether the rows of matrix i of genomeMats are the genes (P: columns).
+func genesAsRows(i int, mat *Spmat, ngenes int) bool {
+	if i < nGenomeMats {
+		return genomeLetters[i] != 'P'
+	}
+	return mat.NRow() == ngenes
+}
@@ -0,0 +1,220 @@
+package multicell
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Settings of a small model with the given recombination.
+func recombinationSettings(edit func(s *Settings)) Settings {
+	s := DefaultSettings()
+	s.MaxPop = 10
+	s.NGenes = 12
+	s.NEnv = 10
+	s.NSel = 5
+	if edit != nil {
+		edit(&s)
+	}
+	return s
+}
+
+// Layers with the Extra matrices Q (genes as columns) and R (no genes).
+func withExtraLayers(s *Settings) {
+	s.Layers = []LayerSpec{
+		{Name: "g", Act: "tanh", Inputs: []LayerInput{{From: "e", Matrix: "E"}}},
+		{Name: "q", Size: 5, Act: "tanh", Inputs: []LayerInput{{From: "g", Matrix: "Q", Density: 0.2}}},
+		{Name: "p", Act: "tanh", Inputs: []LayerInput{{From: "q", Matrix: "R", Density: 0.2}}},
+	}
+}
+
+// Genome with all elements set to v.
+func filledGenome(m *Model, v float64) Genome {
+	G := NewGenome(m)
+	for _, mat := range genomeMats(&G) {
+		for i := 0; i < mat.NRow(); i++ {
+			for j := 0; j < mat.Ncol; j++ {
+				mat.Set(i, j, v)
+			}
+		}
+	}
+	return G
+}
+
+// Parent of each element of each matrix of G0 (0: dad, 1: mom), checking
+// that G1 has the element of the other parent.
+func origins(t *testing.T, G0, G1 *Genome) [][][]int {
+	t.Helper()
+	mats0, mats1 := genomeMats(G0), genomeMats(G1)
+	orig := make([][][]int, len(mats0))
+	for k, mat := range mats0 {
+		orig[k] = make([][]int, mat.NRow())
+		for i := range orig[k] {
+			orig[k][i] = make([]int, mat.Ncol)
+			for j := range orig[k][i] {
+				v0, v1 := mat.At(i, j), mats1[k].At(i, j)
+				switch {
+				case v0 == 1 && v1 == 2:
+				case v0 == 2 && v1 == 1:
+					orig[k][i][j] = 1
+				default:
+					t.Fatalf("matrix %d (%d, %d): offspring %v, %v", k, i, j, v0, v1)
+				}
+			}
+		}
+	}
+	return orig
+}
+
+// Parent of each gene (-1: mixed) and of each matrix without genes. The
+// genes are the rows of a matrix of NGenes rows, otherwise its columns
+// (NEnv != NGenes in the tests).
+func geneOrigins(m *Model, mats []*Spmat, orig [][][]int) (genes []int, whole []int) {
+	genes = make([]int, m.NGenes)
+	for g := range genes {
+		genes[g] = -2 // Not seen
+	}
+	see := func(g, o int) {
+		if genes[g] == -2 {
+			genes[g] = o
+		} else if genes[g] != o {
+			genes[g] = -1
+		}
+	}
+	for k, mat := range mats {
+		if m.frozen[k] {
+			continue
+		}
+		for i := range orig[k] {
+			for j, o := range orig[k][i] {
+				switch {
+				case mat.NRow() == m.NGenes:
+					see(i, o)
+				case mat.Ncol == m.NGenes:
+					see(j, o)
+				default:
+					whole = append(whole, o)
+				}
+			}
+		}
+	}
+	return genes, whole
+}
+
+func TestRecombiners(t *testing.T) {
+	ones := make([]float64, 11)
+	for i := range ones {
+		ones[i] = 1
+	}
+	tests := []struct {
+		name     string
+		edit     func(s *Settings)
+		clonal   bool // offspring 0 is dad
+		rows     bool // each row from one parent
+		loci     bool // each gene from one parent in all matrices
+		switches int  // number of crossovers between genes (loci; -1: any)
+		mixed    bool // some row from both parents
+	}{
+		{"free", nil, false, true, false, -1, false},
+		{"clonal", func(s *Settings) { s.Recombination = "clonal" }, true, true, true, 0, false},
+		{"no crossing", func(s *Settings) { s.CrossRate = 0 }, true, true, true, 0, false},
+		{"kpoint", func(s *Settings) { s.Recombination = "kpoint"; s.CrossPoints = 3 }, false, false, true, 3, false},
+		{"kpoint every gene", func(s *Settings) { s.Recombination = "kpoint"; s.CrossPoints = 11 }, false, false, true, 11, false},
+		{"kpoint extra matrices", func(s *Settings) { withExtraLayers(s); s.Recombination = "kpoint" }, false, false, true, 1, false},
+		{"linkage", func(s *Settings) { s.Recombination = "linkage"; s.RecombRate = 0.3 }, false, false, true, -1, false},
+		{"linkage map unlinked", func(s *Settings) { s.Recombination = "linkage"; s.LinkageMap = ones }, false, false, true, 11, false},
+		{"linkage map linked", func(s *Settings) { s.Recombination = "linkage"; s.LinkageMap = make([]float64, 11) }, false, false, true, 0, false},
+		{"linkage extra matrices", func(s *Settings) { withExtraLayers(s); s.Recombination = "linkage" }, false, false, true, -1, false},
+		{"uniform", func(s *Settings) { s.Recombination = "uniform" }, false, false, false, -1, true},
+		{"kpoint frozen", func(s *Settings) { s.Recombination = "kpoint"; s.Frozen = "GP" }, false, false, true, 1, false},
+		{"uniform frozen", func(s *Settings) { withExtraLayers(s); s.Recombination = "uniform"; s.Frozen = "E,Q" }, false, false, false, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := recombinationSettings(tt.edit)
+			if err := s.Check(); err != nil {
+				t.Fatal(err)
+			}
+			m := NewModel(s)
+			rng := rand.New(rand.NewSource(9))
+			for rep := 0; rep < 10; rep++ {
+				G0, G1 := filledGenome(m, 1), filledGenome(m, 2)
+				mats := genomeMats(&G0)
+				m.recombine(mats, genomeMats(&G1), rng)
+				orig := origins(t, &G0, &G1)
+
+				mixed := false
+				for k := range orig {
+					for i, row := range orig[k] {
+						for _, o := range row {
+							switch {
+							case m.frozen[k] && o != 0:
+								t.Fatalf("frozen matrix %d recombined", k)
+							case tt.clonal && o != 0:
+								t.Fatalf("matrix %d (%d): not clonal", k, i)
+							case o != row[0]:
+								mixed = true
+								if tt.rows {
+									t.Fatalf("matrix %d row %d from both parents", k, i)
+								}
+							}
+						}
+					}
+				}
+				if tt.mixed && !mixed {
+					t.Error("no row from both parents")
+				}
+
+				if !tt.loci {
+					continue
+				}
+				genes, whole := geneOrigins(m, mats, orig)
+				nswitch := 0
+				for g, o := range genes {
+					if o < 0 {
+						t.Fatalf("gene %d from both parents", g)
+					}
+					if g > 0 && o != genes[g-1] {
+						nswitch++
+					}
+				}
+				if tt.switches >= 0 && nswitch != tt.switches {
+					t.Errorf("%d crossovers, want %d", nswitch, tt.switches)
+				}
+				for _, o := range whole {
+					if o != genes[0] {
+						t.Fatal("matrix without genes not inherited with the first gene")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRecombination(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(s *Settings)
+		ok   bool
+	}{
+		{"default", func(s *Settings) {}, true},
+		{"kpoint", func(s *Settings) { s.Recombination = "kpoint"; s.CrossPoints = 11 }, true},
+		{"linkage map", func(s *Settings) { s.Recombination = "linkage"; s.LinkageMap = make([]float64, 11) }, true},
+		{"unknown", func(s *Settings) { s.Recombination = "twopoint" }, false},
+		{"negative cross rate", func(s *Settings) { s.CrossRate = -0.1 }, false},
+		{"cross rate above 1", func(s *Settings) { s.CrossRate = 1.1 }, false},
+		{"no crossover points", func(s *Settings) { s.Recombination = "kpoint"; s.CrossPoints = 0 }, false},
+		{"too many crossover points", func(s *Settings) { s.Recombination = "kpoint"; s.CrossPoints = 12 }, false},
+		{"recombination rate above 1", func(s *Settings) { s.RecombRate = 2 }, false},
+		{"short linkage map", func(s *Settings) { s.LinkageMap = make([]float64, 10) }, false},
+		{"linkage map of rows of P", func(s *Settings) { s.LinkageMap = make([]float64, 9) }, false},
+		{"negative linkage", func(s *Settings) { s.LinkageMap = make([]float64, 11); s.LinkageMap[3] = -1 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := recombinationSettings(tt.edit)
+			if err := s.checkRecombination(); (err == nil) != tt.ok {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package multicell
+
+import (
+	"math/rand"
+
+	exprand "golang.org/x/exp/rand"
+)
+
+// Random number streams.
+/*
+   Every individual (and every mating) draws from its own random number
+   stream whose seed is derived from the run seed and the position of the
+   individual in the run (epoch, generation, id). Results therefore do not
+   depend on the order in which goroutines are scheduled.
+
+   The run seed and the sequential generator of environmental cues are the
+   run state of a Model, so that Models in one process do not share them.
+   A Model rebuilt for the same run (e.g., with the Settings of a loaded
+   population) continues them with ShareRunState.
+*/
+
+// Stream tags to keep different uses of the same (epoch, gen, id) apart.
+const (
+	StreamInit   = iota // Initial randomization of genomes
+	StreamDev           // Development (noise in cues)
+	StreamMate          // Crossover and mutations
+	StreamSelect        // Selection of parents
+)
+
+// Random number generators of a run.
+type runState struct {
+	seed   int64      // Seed of the streams
+	cueSrc *cueSource // Source of cue
+	cue    *rand.Rand // Environmental cues (sequential)
+}
+
+func newRunState() *runState {
+	src := newCueSource(99)
+	return &runState{seed: 1, cueSrc: src, cue: rand.New(src)}
+}
+
+// SetSeed sets the seed of the random number streams of the run.
+func (m *Model) SetSeed(seed int64) {
+	m.run.seed = seed
+}
+
+// Seed returns the seed of the random number streams of the run.
+func (m *Model) Seed() int64 {
+	return m.run.seed
+}
+
+// ShareRunState makes m continue the random number generators of m0.
+func (m *Model) ShareRunState(m0 *Model) {
+	m.run = m0.run
+}
+
+// xoshiro256** generator; small state, cheap to seed.
+type rngSource struct {
+	s [4]uint64
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	z := x
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func rotl(x uint64, k uint) uint64 {
+	return (x << k) | (x >> (64 - k))
+}
+
+func (src *rngSource) Seed(seed int64) {
+	x := uint64(seed)
+	for i := range src.s {
+		x = splitmix64(x)
+		src.s[i] = x
+	}
+}
+
+func (src *rngSource) Uint64() uint64 {
+	s := &src.s
+	result := rotl(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = rotl(s[3], 45)
+	return result
+}
+
+func (src *rngSource) Int63() int64 {
+	return int64(src.Uint64() >> 1)
+}
+
+// Adapter for gonum's distributions (they use golang.org/x/exp/rand).
+type expSource struct {
+	rng *rand.Rand
+}
+
+func (src expSource) Uint64() uint64 {
+	return src.rng.Uint64()
+}
+
+func (src expSource) Seed(seed uint64) {
+	src.rng.Seed(int64(seed))
+}
+
+func distSource(rng *rand.Rand) exprand.Source {
+	return expSource{rng}
+}
+
+// Seed derived from the run seed and a list of keys.
+func streamSeed(seed int64, keys ...int) int64 {
+	h := splitmix64(uint64(seed))
+	for _, k := range keys {
+		h = splitmix64(h ^ uint64(k))
+	}
+	return int64(h)
+}
+
+// NewStream returns an independent random number stream for the given keys
+// (e.g., tag, epoch, generation, individual id).
+func (m *Model) NewStream(keys ...int) *rand.Rand {
+	return newStream(m.run.seed, keys...)
+}
+
+// Stream of a run other than the current one (e.g., read from an archive).
+func newStream(seed int64, keys ...int) *rand.Rand {
+	src := &rngSource{}
+	src.Seed(streamSeed(seed, keys...))
+	return rand.New(src)
+}
@@ -0,0 +1,67 @@
+package multicell
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Fitness of each generation and final genomes of a short run with procs
+// threads.
+func evolveWithProcs(procs int) ([][]float64, []Genome) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	s := DefaultSettings()
+	s.MaxPop = 16
+	m := NewModel(s)
+	m.SetSeed(5)
+	m.SetSeedCue(7)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+
+	var fits [][]float64
+	for gen := 1; gen <= 2; gen++ {
+		pop.DevPop(m, gen)
+		fit := make([]float64, len(pop.Indivs))
+		for i, indiv := range pop.Indivs {
+			fit[i] = indiv.Fit
+		}
+		fits = append(fits, fit)
+		pop = pop.PairReproduce(m, s.MaxPop)
+	}
+	genomes := make([]Genome, len(pop.Indivs))
+	for i, indiv := range pop.Indivs {
+		genomes[i] = *indiv.Bodies[INovEnv].Genome
+	}
+	return fits, genomes
+}
+
+func TestDevPopProcs(t *testing.T) {
+	fits1, genomes1 := evolveWithProcs(1)
+	for _, procs := range []int{4} {
+		fits, genomes := evolveWithProcs(procs)
+		if !reflect.DeepEqual(fits, fits1) {
+			t.Errorf("GOMAXPROCS=%d: fitness %v, with 1: %v", procs, fits, fits1)
+		}
+		if !reflect.DeepEqual(genomes, genomes1) {
+			t.Errorf("GOMAXPROCS=%d: genomes differ from those with 1", procs)
+		}
+	}
+}
+
+// Models of one process must not share random numbers.
+func TestRunStatePerModel(t *testing.T) {
+	s := DefaultSettings()
+	m1, m2 := NewModel(s), NewModel(s)
+	m1.SetSeedCue(3)
+	m2.SetSeedCue(3)
+	c1 := RandomEnv(m1, 0.5)
+	RandomEnv(m1, 0.5)
+	if c2 := RandomEnv(m2, 0.5); !reflect.DeepEqual(c1, c2) {
+		t.Errorf("cues of m2 depend on the draws of m1")
+	}
+	m1.SetSeed(11)
+	if m2.Seed() == 11 {
+		t.Errorf("SetSeed of m1 changed the seed of m2")
+	}
+}
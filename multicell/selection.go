@@ -0,0 +1,214 @@
+package multicell
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Selection and reproduction schemes.
+/*
+   A Selector chooses the parents of the offspring from the developed
+   population; consecutive parents mate (PairReproduce). Selection is
+     wagner      rejection sampling on WagFit (default, as before)
+     sus         stochastic universal sampling proportional to Fit
+                 (Wright-Fisher), in random order
+     tournament  the fittest of Tournament random individuals
+     truncation  uniformly among the fittest fraction TruncFrac
+     moran       proportional to Fit, with overlapping generations
+   Generations are discrete except with moran, a Moran birth-death process
+   with overlapping generations: a generation is as many events as there
+   are individuals, in each of which one offspring (of two parents chosen
+   by fitness) is born and replaces one individual chosen uniformly, so
+   that an individual may die in any event, including one born earlier in
+   the generation. Parents are chosen from the population at the start of
+   the generation, as only it has developed; survivors are their own
+   parents. With Elite > 0, the fittest Elite individuals are copied
+   unchanged into the next generation (moran: they do not die).
+   Individuals of zero fitness are chosen uniformly if all have zero
+   fitness.
+*/
+
+type Selector interface {
+	// n parents in mating order.
+	Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv
+}
+
+type wagnerSelector struct{}
+
+func (wagnerSelector) Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv {
+	return pop.Selection(n, rng)
+}
+
+type susSelector struct{}
+
+func (susSelector) Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv {
+	cum := cumFitness(pop)
+	total := cum[len(cum)-1]
+	parents := make([]Indiv, 0, n)
+	step := total / float64(n)
+	x := rng.Float64() * step
+	k := 0
+	for len(parents) < n {
+		for k < len(cum)-1 && cum[k] <= x {
+			k++
+		}
+		parents = append(parents, pop.Indivs[k])
+		x += step
+	}
+	rng.Shuffle(n, func(i, j int) { parents[i], parents[j] = parents[j], parents[i] })
+	return parents
+}
+
+type tournamentSelector struct{}
+
+func (tournamentSelector) Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv {
+	npop := len(pop.Indivs)
+	parents := make([]Indiv, n)
+	for i := range parents {
+		best := rng.Intn(npop)
+		for t := 1; t < m.Tournament; t++ {
+			k := rng.Intn(npop)
+			if pop.Indivs[k].Fit > pop.Indivs[best].Fit {
+				best = k
+			}
+		}
+		parents[i] = pop.Indivs[best]
+	}
+	return parents
+}
+
+type truncationSelector struct{}
+
+func (truncationSelector) Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv {
+	ranked := pop.ranked()
+	nbest := int(m.TruncFrac*float64(len(ranked)) + 0.5)
+	if nbest < 1 {
+		nbest = 1
+	}
+	parents := make([]Indiv, n)
+	for i := range parents {
+		parents[i] = pop.Indivs[ranked[rng.Intn(nbest)]]
+	}
+	return parents
+}
+
+type moranSelector struct{}
+
+func (moranSelector) Parents(m *Model, pop *Population, n int, rng *rand.Rand) []Indiv {
+	cum := cumFitness(pop)
+	total := cum[len(cum)-1]
+	parents := make([]Indiv, n)
+	for i := range parents {
+		x := rng.Float64() * total
+		k := sort.Search(len(cum), func(j int) bool { return cum[j] > x })
+		if k == len(cum) {
+			k--
+		}
+		parents[i] = pop.Indivs[k]
+	}
+	return parents
+}
+
+var selectors = map[string]Selector{
+	"wagner":     wagnerSelector{},
+	"sus":        susSelector{},
+	"tournament": tournamentSelector{},
+	"truncation": truncationSelector{},
+	"moran":      moranSelector{},
+}
+
+func SelectionNames() []string {
+	names := make([]string, 0, len(selectors))
+	for name := range selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Settings) checkSelection() error {
+	switch _, ok := selectors[s.Selection]; {
+	case !ok:
+		return fmt.Errorf("unknown selection %q (known: %v)", s.Selection, SelectionNames())
+	case s.Tournament < 1:
+		return fmt.Errorf("Tournament must be at least 1")
+	case s.TruncFrac <= 0 || s.TruncFrac > 1:
+		return fmt.Errorf("TruncFrac must be in (0, 1]")
+	case s.Elite < 0 || s.Elite >= s.MaxPop:
+		return fmt.Errorf("Elite must be in [0, MaxPop)")
+	}
+	return nil
+}
+
+// Cumulative fitness of the individuals; all 1 if all have zero fitness.
+func cumFitness(pop *Population) []float64 {
+	cum := make([]float64, len(pop.Indivs))
+	sum := 0.0
+	for i, indiv := range pop.Indivs {
+		sum += indiv.Fit
+		cum[i] = sum
+	}
+	if sum == 0 {
+		for i := range cum {
+			cum[i] = float64(i + 1)
+		}
+	}
+	return cum
+}
+
+// Positions of the individuals from the fittest down (ties by position).
+func (pop *Population) ranked() []int {
+	idx := make([]int, len(pop.Indivs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return pop.Indivs[idx[a]].Fit > pop.Indivs[idx[b]].Fit })
+	return idx
+}
+
+// Offspring of indiv with its genomes unchanged (and new cells).
+func cloneIndiv(m *Model, indiv *Indiv) Indiv {
+	bodies := make([]Body, len(indiv.Bodies))
+	for k := range bodies {
+		bodies[k] = NewBody(m)
+		genome := indiv.Bodies[k].Genome.Copy()
+		bodies[k].Genome = &genome
+		if m.SharedGenome && k > 0 {
+			bodies[k].Genome = bodies[0].Genome
+		}
+	}
+	return Indiv{indiv.Id, indiv.Id, indiv.Id, bodies, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0, nil}
+}
+
+// Next generation by the Selection of m.
+func (pop *Population) reproduce(m *Model) Population {
+	if m.Selection == "moran" {
+		return pop.MoranReproduce(m)
+	}
+	return pop.PairReproduce(m, m.MaxPop)
+}
+
+// Moran process: one birth and one death in each of len(pop.Indivs)
+// events; the Elite never die.
+func (pop *Population) MoranReproduce(m *Model) Population {
+	rng := m.NewStream(StreamSelect, pop.Epoch, pop.Gen)
+	npop := len(pop.Indivs)
+	nindivs := make([]Indiv, npop)
+	for i := range pop.Indivs {
+		nindivs[i] = cloneIndiv(m, &pop.Indivs[i])
+	}
+	mortal := pop.ranked()[m.Elite:]
+
+	parents := m.selector.Parents(m, pop, 2*npop, rng)
+	for event := 0; event < npop; event++ {
+		mrng := m.NewStream(StreamMate, pop.Epoch, pop.Gen, event)
+		kid, _ := Mate(m, &parents[2*event], &parents[2*event+1], mrng)
+		nindivs[mortal[rng.Intn(len(mortal))]] = kid
+	}
+
+	for i := range nindivs {
+		nindivs[i].Id = i
+	}
+	return Population{PopFormatVersion, pop.Params, pop.Epoch, 0, pop.NovEnvs, pop.AncEnvs, pop.Envs, nindivs, pop.Provenance}
+}
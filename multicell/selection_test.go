@@ -0,0 +1,205 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Small model with the given selection.
+func selectionModel(edit func(s *Settings)) *Model {
+	s := DefaultSettings()
+	s.MaxPop = 10
+	s.NGenes = 12
+	s.NEnv = 10
+	s.NSel = 5
+	edit(&s)
+	return NewModel(s)
+}
+
+// Population of individuals with the given fitness (no genomes).
+func fitnessPopulation(m *Model, fits []float64) Population {
+	pop := Population{Params: m.Settings, Indivs: make([]Indiv, len(fits))}
+	for i, f := range fits {
+		pop.Indivs[i] = Indiv{Id: i, Fit: f}
+	}
+	pop.SetWagnerFitness()
+	return pop
+}
+
+func TestSelectors(t *testing.T) {
+	const n = 2000
+	ordered := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	oneFit := []float64{0, 0, 1, 0, 0, 0, 0, 0, 0, 0}
+	zeros := make([]float64, 10)
+	inf := []float64{math.Inf(1), math.Inf(1), math.Inf(1)} // WagFit NaN
+
+	tests := []struct {
+		name      string
+		selection string
+		edit      func(s *Settings)
+		fits      []float64
+		only      int  // Id of the only parent; -1: any
+		all       bool // every individual is a parent
+		fitter    bool // parents fitter than the mean
+	}{
+		{"wagner ordered", "wagner", nil, ordered, -1, false, true},
+		{"wagner one fit", "wagner", func(s *Settings) { s.MinWagnerFitness = 0 }, oneFit, 2, false, false},
+		{"wagner none converged", "wagner", nil, zeros, -1, true, false},
+		{"wagner infinite fitness", "wagner", nil, inf, -1, true, false},
+		{"sus ordered", "sus", nil, ordered, -1, true, true},
+		{"sus one fit", "sus", nil, oneFit, 2, false, false},
+		{"sus none converged", "sus", nil, zeros, -1, true, false},
+		{"tournament ordered", "tournament", func(s *Settings) { s.Tournament = 3 }, ordered, -1, false, true},
+		{"tournament of one", "tournament", func(s *Settings) { s.Tournament = 1 }, oneFit, -1, true, false},
+		{"tournament none converged", "tournament", nil, zeros, -1, true, false},
+		{"truncation ordered", "truncation", func(s *Settings) { s.TruncFrac = 0.3 }, ordered, -1, false, true},
+		{"truncation best", "truncation", func(s *Settings) { s.TruncFrac = 0.1 }, oneFit, 2, false, false},
+		{"truncation none converged", "truncation", func(s *Settings) { s.TruncFrac = 1 }, zeros, -1, true, false},
+		{"moran ordered", "moran", nil, ordered, -1, true, true},
+		{"moran one fit", "moran", nil, oneFit, 2, false, false},
+		{"moran none converged", "moran", nil, zeros, -1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := selectionModel(func(s *Settings) {
+				s.Selection = tt.selection
+				if tt.edit != nil {
+					tt.edit(s)
+				}
+			})
+			pop := fitnessPopulation(m, tt.fits)
+			parents := m.selector.Parents(m, &pop, n, rand.New(rand.NewSource(1)))
+			if len(parents) != n {
+				t.Fatalf("%d parents, want %d", len(parents), n)
+			}
+			count := make([]int, len(tt.fits))
+			mean := 0.0
+			for _, p := range parents {
+				count[p.Id]++
+				mean += p.Fit / n
+			}
+			for id, c := range count {
+				if tt.only >= 0 && id != tt.only && c > 0 {
+					t.Errorf("individual %d (fitness %v) chosen %d times", id, tt.fits[id], c)
+				}
+				if tt.all && c == 0 {
+					t.Errorf("individual %d never chosen", id)
+				}
+			}
+			if tt.fitter && mean <= 5.5 {
+				t.Errorf("mean fitness of parents %v, not above that of the population", mean)
+			}
+		})
+	}
+}
+
+func TestCheckSelection(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(s *Settings)
+		ok   bool
+	}{
+		{"default", func(s *Settings) {}, true},
+		{"all known", func(s *Settings) { s.Selection = "moran"; s.Elite = 9; s.TruncFrac = 1 }, true},
+		{"unknown", func(s *Settings) { s.Selection = "roulette" }, false},
+		{"tournament of zero", func(s *Settings) { s.Tournament = 0 }, false},
+		{"no truncation fraction", func(s *Settings) { s.TruncFrac = 0 }, false},
+		{"truncation fraction above 1", func(s *Settings) { s.TruncFrac = 1.5 }, false},
+		{"negative elite", func(s *Settings) { s.Elite = -1 }, false},
+		{"elite of whole population", func(s *Settings) { s.Elite = 10 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := DefaultSettings()
+			s.MaxPop = 10
+			tt.edit(&s)
+			if err := s.checkSelection(); (err == nil) != tt.ok {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
+
+// Genomes marked by position (E[0][0] = position + 1), with only the first
+// individual fit.
+func markedPopulation(m *Model) Population {
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	for i := range pop.Indivs {
+		pop.Indivs[i].Id = 100 + i
+		for k := range pop.Indivs[i].Bodies {
+			pop.Indivs[i].Bodies[k].Genome.E.Set(0, 0, float64(i+1))
+		}
+	}
+	pop.Indivs[0].Fit = 1
+	return pop
+}
+
+func TestElite(t *testing.T) {
+	for _, elite := range []int{1, 4} {
+		m := selectionModel(func(s *Settings) { s.Elite = elite; s.MutRate = 0 })
+		pop := markedPopulation(m)
+		for i := range pop.Indivs {
+			pop.Indivs[i].Fit = float64(i % 5) // fittest at positions 4, 9, 3, 8
+		}
+		pop.SetWagnerFitness()
+		pop1 := pop.PairReproduce(m, m.MaxPop)
+		if len(pop1.Indivs) != m.MaxPop {
+			t.Fatalf("elite %d: %d individuals, want %d", elite, len(pop1.Indivs), m.MaxPop)
+		}
+		for p, want := range []int{4, 9, 3, 8}[:elite] {
+			indiv := pop1.Indivs[p]
+			if marker := indiv.Bodies[INovEnv].Genome.E.At(0, 0); marker != float64(want+1) || indiv.DadId != 100+want {
+				t.Errorf("elite %d: position %d has marker %v from %d, want %d", elite, p, marker, indiv.DadId, want+1)
+			}
+		}
+	}
+}
+
+// Each of the MaxPop events replaces an individual other than the Elite,
+// uniformly, so that nm mortal positions keep their individual with
+// probability (1 - 1/nm)^MaxPop.
+func TestMoranReproduce(t *testing.T) {
+	const ngen = 400
+	for _, elite := range []int{1, 3, 8, 9} {
+		t.Run(fmt.Sprintf("elite %d", elite), func(t *testing.T) {
+			m := selectionModel(func(s *Settings) {
+				s.Selection = "moran"
+				s.Elite = elite
+				s.MutRate = 0
+			})
+			pop := markedPopulation(m)
+			nbirth := 0
+			for gen := 0; gen < ngen; gen++ {
+				pop.Gen = gen
+				pop1 := pop.MoranReproduce(m)
+				if len(pop1.Indivs) != len(pop.Indivs) {
+					t.Fatalf("%d individuals, want %d", len(pop1.Indivs), len(pop.Indivs))
+				}
+				for p, indiv := range pop1.Indivs {
+					if indiv.Id != p {
+						t.Errorf("Id %d at position %d", indiv.Id, p)
+					}
+					marker := indiv.Bodies[INovEnv].Genome.E.At(0, 0)
+					switch {
+					case marker == float64(p+1) && indiv.DadId == 100+p: // survivor
+					case marker == 1 && indiv.DadId == 100 && indiv.MomId == 100: // offspring of the fit
+						if p < elite {
+							t.Fatalf("elite %d replaced", p)
+						}
+						nbirth++
+					default:
+						t.Fatalf("position %d: marker %v, parents (%d, %d)", p, marker, indiv.DadId, indiv.MomId)
+					}
+				}
+			}
+			npop, nm := float64(m.MaxPop), float64(m.MaxPop-elite)
+			want := nm * (1 - math.Pow(1-1/nm, npop))
+			if got := float64(nbirth) / ngen; math.Abs(got-want) > 0.2 {
+				t.Errorf("%.2f individuals replaced per generation, want %.2f", got, want)
+			}
+		})
+	}
+}
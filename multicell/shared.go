@@ -0,0 +1,62 @@
+package multicell
+
+import (
+	"errors"
+)
+
+// One genome for all bodies of an individual.
+/*
+   By default, each body of an individual (Anc, Nov, ...) has its own
+   mutations of the genome of the offspring (see Mate), so the phenotypes in
+   different environments come from different genotypes. With SharedGenome,
+   the offspring genome is mutated once and all bodies develop it (Body.Genome
+   points to the same Genome), so the bodies give the reaction norm of one
+   genotype.
+
+   Files then store the genome only in the first body of each individual
+   (also in checkpoints and archives): Genome is nil in the others, and
+   reading fills them in.
+*/
+
+// Population to be written: the genomes of all bodies but the first are
+// nil with SharedGenome.
+func (pop *Population) stored() *Population {
+	if !pop.Params.SharedGenome {
+		return pop
+	}
+	pop1 := *pop
+	pop1.Indivs = make([]Indiv, len(pop.Indivs))
+	for i, indiv := range pop.Indivs {
+		pop1.Indivs[i] = indiv
+		pop1.Indivs[i].Bodies = make([]Body, len(indiv.Bodies))
+		for k, body := range indiv.Bodies {
+			if k > 0 {
+				body.Genome = nil
+			}
+			pop1.Indivs[i].Bodies[k] = body
+		}
+	}
+	return &pop1
+}
+
+// Gives all bodies the genome of the first with SharedGenome.
+func (pop *Population) shareGenomes() {
+	if !pop.Params.SharedGenome {
+		return
+	}
+	for _, indiv := range pop.Indivs {
+		for k := 1; k < len(indiv.Bodies); k++ {
+			indiv.Bodies[k].Genome = indiv.Bodies[0].Genome
+		}
+	}
+}
+
+// RequireDistinctGenomes returns an error with SharedGenome, when the
+// genomes of the bodies of an individual are one and their differences
+// vanish.
+func (pop *Population) RequireDistinctGenomes() error {
+	if pop.Params.SharedGenome {
+		return errors.New("shared genome: no genotype difference between environments")
+	}
+	return nil
+}
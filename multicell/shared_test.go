@@ -0,0 +1,64 @@
+package multicell
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSharedGenomeFile(t *testing.T) {
+	s := DefaultSettings()
+	s.MaxPop = 4
+	s.NGenes = 20
+	s.NEnv = 16
+	s.NSel = 4
+	s.NEnvs = 3
+	s.SharedGenome = true
+	m := NewModel(s)
+	pop := NewPopulation(m)
+	pop.RandomizeGenome(m)
+	pop.SetRandomNovEnvs(m)
+
+	data, err := json.Marshal(pop.stored())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `"Genome"`); n != len(pop.Indivs) {
+		t.Errorf("%d genomes stored for %d individuals", n, len(pop.Indivs))
+	}
+
+	var shared bytes.Buffer
+	if err := pop.Save(&shared); err != nil {
+		t.Fatal(err)
+	}
+
+	var pop1 Population
+	if err := pop1.Load(&shared); err != nil {
+		t.Fatal(err)
+	}
+	for i, indiv := range pop1.Indivs {
+		G0 := indiv.Bodies[0].Genome
+		assertEqualGenomes(t, "body 0", G0, pop.Indivs[i].Bodies[0].Genome)
+		for k := 1; k < len(indiv.Bodies); k++ {
+			if indiv.Bodies[k].Genome != G0 {
+				t.Fatalf("indiv %d: body %d does not share the genome", i, k)
+			}
+		}
+	}
+
+	if pop.RequireDistinctGenomes() == nil {
+		t.Error("shared genomes are distinct")
+	}
+
+	// Without SharedGenome, every body must have its genome.
+	pop2 := pop.Copy()
+	pop2.Params.SharedGenome = false
+	if err := pop2.RequireDistinctGenomes(); err != nil {
+		t.Error(err)
+	}
+	pop2.Indivs[0].Bodies[1].Genome = nil
+	if err := pop2.Validate(); err == nil {
+		t.Error("body without genome is valid")
+	}
+}
@@ -1,82 +1,215 @@
 package multicell
 
 import (
-	//	"math"
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"sort"
 
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
+// Sparse matrix in compressed sparse row (CSR) format.
+/*
+   The elements of row i are Col[k], Val[k] for RowPtr[i] <= k < RowPtr[i+1],
+   with Col increasing within a row and no explicit zeros. Rows are summed
+   in column order, so results do not depend on how a matrix was built.
+*/
 type Spmat struct {
-	Ncol int                 // number of columns
-	Mat  [](map[int]float64) // Sparse matrix is an array of maps.
+	Ncol   int       // number of columns
+	RowPtr []int     // len = number of rows + 1
+	Col    []int     // column indices
+	Val    []float64 // values
 }
 
 func NewSpmat(nrow, ncol int) Spmat { //Initialize new sparse matrix
-	mat := make([](map[int]float64), nrow)
-	for i := range mat {
-		mat[i] = make(map[int]float64)
+	return Spmat{Ncol: ncol, RowPtr: make([]int, nrow+1)}
+}
+
+func (sp *Spmat) NRow() int {
+	if len(sp.RowPtr) == 0 {
+		return 0
 	}
-	return Spmat{ncol, mat}
+	return len(sp.RowPtr) - 1
 }
 
-func (sp *Spmat) Copy() Spmat {
-	nsp := NewSpmat(len(sp.Mat), sp.Ncol)
-	for i, m := range sp.Mat {
-		for j, d := range m {
-			nsp.Mat[i][j] = d
+// Row returns the column indices and values of row i (not copies).
+func (sp *Spmat) Row(i int) ([]int, []float64) {
+	k0, k1 := sp.RowPtr[i], sp.RowPtr[i+1]
+	return sp.Col[k0:k1], sp.Val[k0:k1]
+}
+
+// Position of (i, j) in Col and Val, and whether the element is present.
+func (sp *Spmat) find(i, j int) (int, bool) {
+	k0, k1 := sp.RowPtr[i], sp.RowPtr[i+1]
+	k := k0 + sort.SearchInts(sp.Col[k0:k1], j)
+	return k, k < k1 && sp.Col[k] == j
+}
+
+func (sp *Spmat) At(i, j int) float64 {
+	if k, ok := sp.find(i, j); ok {
+		return sp.Val[k]
+	}
+	return 0
+}
+
+// Set sets element (i, j) to v; v = 0 removes the element.
+func (sp *Spmat) Set(i, j int, v float64) {
+	k, ok := sp.find(i, j)
+	switch {
+	case ok && v != 0:
+		sp.Val[k] = v
+		return
+	case ok:
+		sp.Col = append(sp.Col[:k], sp.Col[k+1:]...)
+		sp.Val = append(sp.Val[:k], sp.Val[k+1:]...)
+		for r := i + 1; r < len(sp.RowPtr); r++ {
+			sp.RowPtr[r]--
+		}
+	case v != 0:
+		sp.Col = append(sp.Col, 0)
+		sp.Val = append(sp.Val, 0)
+		copy(sp.Col[k+1:], sp.Col[k:])
+		copy(sp.Val[k+1:], sp.Val[k:])
+		sp.Col[k] = j
+		sp.Val[k] = v
+		for r := i + 1; r < len(sp.RowPtr); r++ {
+			sp.RowPtr[r]++
 		}
 	}
+}
+
+// Appends a row (columns increasing) while building a matrix row by row.
+func (sp *Spmat) appendRow(cols []int, vals []float64) {
+	sp.Col = append(sp.Col, cols...)
+	sp.Val = append(sp.Val, vals...)
+	sp.RowPtr = append(sp.RowPtr, len(sp.Col))
+}
+
+// Removes all elements.
+func (sp *Spmat) Clear() {
+	for i := range sp.RowPtr {
+		sp.RowPtr[i] = 0
+	}
+	sp.Col = sp.Col[:0]
+	sp.Val = sp.Val[:0]
+}
+
+func (sp *Spmat) Copy() Spmat {
+	nsp := Spmat{Ncol: sp.Ncol}
+	nsp.RowPtr = append([]int(nil), sp.RowPtr...)
+	nsp.Col = append([]int(nil), sp.Col...)
+	nsp.Val = append([]float64(nil), sp.Val...)
 	return nsp
 }
 
-func (sp *Spmat) Randomize(density float64) { //Randomize entries of sparse matrix
+// Resize drops the rows and columns beyond nrow and ncol, and adds empty
+// rows up to nrow.
+func (sp *Spmat) Resize(nrow, ncol int) {
+	old := sp.Copy()
+	sp.Ncol = ncol
+	sp.RowPtr = sp.RowPtr[:1]
+	sp.Col = sp.Col[:0]
+	sp.Val = sp.Val[:0]
+	for i := 0; i < nrow; i++ {
+		if i < old.NRow() {
+			cols, vals := old.Row(i)
+			n := sort.SearchInts(cols, ncol)
+			sp.appendRow(cols[:n], vals[:n])
+		} else {
+			sp.appendRow(nil, nil)
+		}
+	}
+}
+
+// Dense row i appended to vec.
+func (sp *Spmat) appendDenseRow(vec Vec, i int) Vec {
+	n := len(vec)
+	vec = append(vec, make(Vec, sp.Ncol)...)
+	cols, vals := sp.Row(i)
+	for k, j := range cols {
+		vec[n+j] = vals[k]
+	}
+	return vec
+}
+
+// Randomize sets each element with probability density to a new weight
+// of magnitude mag(rng) and random sign.
+func (sp *Spmat) Randomize(density float64, mag func(*rand.Rand) float64, rng *rand.Rand) {
 	if density == 0 {
 		return
 	}
 
 	density2 := density / 2
-	for i := range sp.Mat {
+	nrow := sp.NRow()
+	sp.RowPtr = sp.RowPtr[:1]
+	sp.Col = sp.Col[:0]
+	sp.Val = sp.Val[:0]
+	for i := 0; i < nrow; i++ {
 		for j := 0; j < sp.Ncol; j++ {
-			r := rand.Float64()
+			r := rng.Float64()
 			if r < density2 {
-				sp.Mat[i][j] = 1
+				sp.Col = append(sp.Col, j)
+				sp.Val = append(sp.Val, mag(rng))
 			} else if r < density {
-				sp.Mat[i][j] = -1
+				sp.Col = append(sp.Col, j)
+				sp.Val = append(sp.Val, -mag(rng))
 			}
 		}
+		sp.RowPtr = append(sp.RowPtr, len(sp.Col))
 	}
 }
 
-func DiffSpmat(m1, m2 *Spmat) Spmat { //This function works fine
-	d := NewSpmat(len(m1.Mat), m1.Ncol) //initialization
-	ncol := m1.Ncol
-	for i := range m1.Mat {
-		for j := 0; j < ncol; j++ {
-			d.Mat[i][j] = m1.Mat[i][j] - m2.Mat[i][j]
+// Calls f for each column j present in either row (columns increasing),
+// with the values a and b of the two rows (0 if absent).
+func mergeRows(c1 []int, v1 []float64, c2 []int, v2 []float64, f func(j int, a, b float64)) {
+	k1, k2 := 0, 0
+	for k1 < len(c1) || k2 < len(c2) {
+		switch {
+		case k2 == len(c2) || (k1 < len(c1) && c1[k1] < c2[k2]):
+			f(c1[k1], v1[k1], 0)
+			k1++
+		case k1 == len(c1) || c2[k2] < c1[k1]:
+			f(c2[k2], 0, v2[k2])
+			k2++
+		default:
+			f(c1[k1], v1[k1], v2[k2])
+			k1++
+			k2++
 		}
 	}
+}
+
+func DiffSpmat(m1, m2 *Spmat) Spmat { //This function works fine
+	d := Spmat{Ncol: m1.Ncol, RowPtr: []int{0}}
+	for i := 0; i < m1.NRow(); i++ {
+		c1, v1 := m1.Row(i)
+		c2, v2 := m2.Row(i)
+		mergeRows(c1, v1, c2, v2, func(j int, a, b float64) {
+			if a != b {
+				d.Col = append(d.Col, j)
+				d.Val = append(d.Val, a-b)
+			}
+		})
+		d.RowPtr = append(d.RowPtr, len(d.Col))
+	}
 
 	return d
 }
 
 func (sp *Spmat) Scale(c float64) {
-	for i, mi := range sp.Mat {
-		for j := range mi {
-			sp.Mat[i][j] *= c
-		}
+	for k := range sp.Val {
+		sp.Val[k] *= c
 	}
 }
 
 func MultMatVec(vout Vec, mat Spmat, vin Vec) { //Matrix multiplication
 	for i := range vout {
-		vout[i] = 0.0
-	}
-
-	for i, m := range mat.Mat {
-		for j, d := range m {
-			vout[i] += d * vin[j]
+		v := 0.0
+		for k := mat.RowPtr[i]; k < mat.RowPtr[i+1]; k++ {
+			v += mat.Val[k] * vin[mat.Col[k]]
 		}
+		vout[i] = v
 	}
 	return
 }
@@ -85,35 +218,35 @@ func MultMatVec_T(vout Vec, mat Spmat, vin Vec) { //Matrix transposition and the
 	for i := range vout {
 		vout[i] = 0.0
 	}
-	for i, m := range mat.Mat {
-		vi := vin[i]
-		for j, d := range m {
-			vout[j] += d * vi
+	for i, vi := range vin {
+		for k := mat.RowPtr[i]; k < mat.RowPtr[i+1]; k++ {
+			vout[mat.Col[k]] += mat.Val[k] * vi
 		}
 	}
 
 	return
 }
 
-func (mat *Spmat) mutateSpmat(density, mutrate float64) { //mutating a sparse matrix
+func (mat *Spmat) mutateSpmat(density, mutrate float64, mag func(*rand.Rand) float64, rng *rand.Rand) { //mutating a sparse matrix
 	if density == 0.0 {
 		return
 	}
 
-	nrow := len(mat.Mat)
+	nrow := mat.NRow()
 	lambda := mutrate * float64(nrow*mat.Ncol)
-	dist := distuv.Poisson{Lambda: lambda}
+	dist := distuv.Poisson{Lambda: lambda, Src: distSource(rng)}
 	nmut := int(dist.Rand())
 	density2 := density * 0.5
 	for n := 0; n < nmut; n++ {
-		i := rand.Intn(nrow)
-		j := rand.Intn(mat.Ncol)
-		r := rand.Float64()
-		delete(mat.Mat[i], j)
+		i := rng.Intn(nrow)
+		j := rng.Intn(mat.Ncol)
+		r := rng.Float64()
 		if r < density2 {
-			mat.Mat[i][j] = 1.0
+			mat.Set(i, j, mag(rng))
 		} else if r < density {
-			mat.Mat[i][j] = -1.0
+			mat.Set(i, j, -mag(rng))
+		} else {
+			mat.Set(i, j, 0.0)
 		}
 	}
 
@@ -122,39 +255,168 @@ func (mat *Spmat) mutateSpmat(density, mutrate float64) { //mutating a sparse ma
 }
 
 // point mutation
-func (mat *Spmat) pMutateSpmat(density float64, irow, icol int) {
+func (mat *Spmat) pMutateSpmat(density float64, irow, icol int, mag func(*rand.Rand) float64, rng *rand.Rand) {
 	if density == 0.0 {
 		return
 	}
 
-	r := rand.Float64()
-	delete(mat.Mat[irow], icol)
+	r := rng.Float64()
 	if r < density/2 {
-		mat.Mat[irow][icol] = 1.0
+		mat.Set(irow, icol, mag(rng))
 	} else if r < density {
-		mat.Mat[irow][icol] = -1.0
+		mat.Set(irow, icol, -mag(rng))
+	} else {
+		mat.Set(irow, icol, 0.0)
 	}
 	return
 }
 
 func DotSpmats(mat0, mat1 Spmat) float64 {
 	dot := 0.0
-	for i, m := range mat0.Mat {
-		for j, d := range m {
-			dot += d * mat1.Mat[i][j]
+	for i := 0; i < mat0.NRow(); i++ {
+		cols, vals := mat0.Row(i)
+		for k, j := range cols {
+			dot += vals[k] * mat1.At(i, j)
 		}
 	}
 
 	return dot
 }
 
-func CrossoverSpmats(mat0, mat1 Spmat) {
-	for i, ri := range mat0.Mat {
-		r := rand.Float64()
-		if r < 0.5 {
-			mat0.Mat[i] = mat1.Mat[i]
-			mat1.Mat[i] = ri
+// Swaps each row between the two matrices with probability 1/2.
+func CrossoverSpmats(mat0, mat1 *Spmat, rng *rand.Rand) {
+	swap := make([]bool, mat0.NRow())
+	for i := range swap {
+		swap[i] = rng.Float64() < 0.5
+	}
+	SwapRows(mat0, mat1, swap)
+}
+
+// Swaps the rows i with swap[i] between the two matrices.
+func SwapRows(mat0, mat1 *Spmat, swap []bool) {
+	nrow := mat0.NRow()
+	new0 := Spmat{Ncol: mat0.Ncol, RowPtr: make([]int, 1, nrow+1), Col: make([]int, 0, len(mat0.Col)), Val: make([]float64, 0, len(mat0.Val))}
+	new1 := Spmat{Ncol: mat1.Ncol, RowPtr: make([]int, 1, nrow+1), Col: make([]int, 0, len(mat1.Col)), Val: make([]float64, 0, len(mat1.Val))}
+	for i := 0; i < nrow; i++ {
+		c0, v0 := mat0.Row(i)
+		c1, v1 := mat1.Row(i)
+		if swap[i] {
+			c0, v0, c1, v1 = c1, v1, c0, v0
 		}
+		new0.appendRow(c0, v0)
+		new1.appendRow(c1, v1)
+	}
+	*mat0 = new0
+	*mat1 = new1
+}
+
+// Swaps each element (present in either matrix) with probability 1/2.
+func CrossoverElements(mat0, mat1 *Spmat, rng *rand.Rand) {
+	swapElements(mat0, mat1, func(j int) bool { return rng.Float64() < 0.5 })
+}
+
+// Swaps the columns j with swap[j] between the two matrices.
+func SwapCols(mat0, mat1 *Spmat, swap []bool) {
+	swapElements(mat0, mat1, func(j int) bool { return swap[j] })
+}
+
+// Swaps the elements in columns j with swap(j), row by row.
+func swapElements(mat0, mat1 *Spmat, swap func(j int) bool) {
+	nrow := mat0.NRow()
+	new0 := Spmat{Ncol: mat0.Ncol, RowPtr: make([]int, 1, nrow+1), Col: make([]int, 0, len(mat0.Col)), Val: make([]float64, 0, len(mat0.Val))}
+	new1 := Spmat{Ncol: mat1.Ncol, RowPtr: make([]int, 1, nrow+1), Col: make([]int, 0, len(mat1.Col)), Val: make([]float64, 0, len(mat1.Val))}
+	for i := 0; i < nrow; i++ {
+		c0, v0 := mat0.Row(i)
+		c1, v1 := mat1.Row(i)
+		mergeRows(c0, v0, c1, v1, func(j int, a, b float64) {
+			if swap(j) {
+				a, b = b, a
+			}
+			if a != 0 {
+				new0.Col = append(new0.Col, j)
+				new0.Val = append(new0.Val, a)
+			}
+			if b != 0 {
+				new1.Col = append(new1.Col, j)
+				new1.Val = append(new1.Val, b)
+			}
+		})
+		new0.RowPtr = append(new0.RowPtr, len(new0.Col))
+		new1.RowPtr = append(new1.RowPtr, len(new1.Col))
 	}
+	*mat0 = new0
+	*mat1 = new1
+}
 
+// Checks the structure of a matrix (e.g., after reading it from a file).
+func (sp *Spmat) check() error {
+	if len(sp.RowPtr) == 0 || sp.RowPtr[0] != 0 {
+		return fmt.Errorf("sparse matrix: RowPtr must start with 0")
+	}
+	if len(sp.Col) != len(sp.Val) || sp.RowPtr[len(sp.RowPtr)-1] != len(sp.Col) {
+		return fmt.Errorf("sparse matrix: %d columns and %d values for %d elements",
+			len(sp.Col), len(sp.Val), sp.RowPtr[len(sp.RowPtr)-1])
+	}
+	for i := 0; i < sp.NRow(); i++ {
+		if sp.RowPtr[i+1] < sp.RowPtr[i] {
+			return fmt.Errorf("sparse matrix: RowPtr decreasing at row %d", i)
+		}
+		cols, _ := sp.Row(i)
+		for k, j := range cols {
+			if j < 0 || j >= sp.Ncol || (k > 0 && j <= cols[k-1]) {
+				return fmt.Errorf("sparse matrix: column %d of row %d out of range or order", j, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Builds a matrix from rows of maps.
+func spmatFromMaps(ncol int, rows []map[int]float64) Spmat {
+	sp := Spmat{Ncol: ncol, RowPtr: make([]int, 1, len(rows)+1)}
+	var keys []int
+	for _, row := range rows {
+		keys = keys[:0]
+		for j, v := range row {
+			if v != 0 {
+				keys = append(keys, j)
+			}
+		}
+		sort.Ints(keys)
+		for _, j := range keys {
+			sp.Col = append(sp.Col, j)
+			sp.Val = append(sp.Val, row[j])
+		}
+		sp.RowPtr = append(sp.RowPtr, len(sp.Col))
+	}
+	return sp
+}
+
+// JSON files store a sparse matrix in CSR form (Ncol, RowPtr, Col, Val)
+// since format version 16; older files have an array of maps (one per row)
+// in Mat, which is still read. The structure is checked by Validate.
+type jsonSpmat struct {
+	Ncol   int
+	RowPtr []int             `json:",omitempty"`
+	Col    []int             `json:",omitempty"`
+	Val    []float64         `json:",omitempty"`
+	Mat    []map[int]float64 `json:",omitempty"`
+}
+
+func (sp Spmat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSpmat{Ncol: sp.Ncol, RowPtr: sp.RowPtr, Col: sp.Col, Val: sp.Val})
+}
+
+func (sp *Spmat) UnmarshalJSON(data []byte) error {
+	var js jsonSpmat
+	err := json.Unmarshal(data, &js)
+	if err != nil {
+		return err
+	}
+	if js.RowPtr == nil { // Before format version 16
+		*sp = spmatFromMaps(js.Ncol, js.Mat)
+		return nil
+	}
+	*sp = Spmat{Ncol: js.Ncol, RowPtr: js.RowPtr, Col: js.Col, Val: js.Val}
+	return nil
 }
@@ -0,0 +1,240 @@
+package multicell
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// Sparse matrix with the elements of a dense one.
+func spmatOf(dense [][]float64, ncol int) Spmat {
+	sp := NewSpmat(len(dense), ncol)
+	for i, row := range dense {
+		for j, v := range row {
+			sp.Set(i, j, v)
+		}
+	}
+	return sp
+}
+
+// Dense form of a sparse matrix.
+func denseOf(sp *Spmat) [][]float64 {
+	dense := make([][]float64, sp.NRow())
+	for i := range dense {
+		dense[i] = make([]float64, sp.Ncol)
+		cols, vals := sp.Row(i)
+		for k, j := range cols {
+			dense[i][j] = vals[k]
+		}
+	}
+	return dense
+}
+
+func TestSpmatSet(t *testing.T) {
+	type set struct {
+		i, j int
+		v    float64
+	}
+	tests := []struct {
+		name string
+		sets []set
+		want [][]float64
+		nnz  int
+	}{
+		{"empty", nil, [][]float64{{0, 0, 0}, {0, 0, 0}}, 0},
+		{"insert out of order", []set{{1, 2, 3}, {0, 1, 1}, {1, 0, 2}, {0, 0, -1}},
+			[][]float64{{-1, 1, 0}, {2, 0, 3}}, 4},
+		{"overwrite", []set{{0, 1, 1}, {0, 1, 5}}, [][]float64{{0, 5, 0}, {0, 0, 0}}, 1},
+		{"remove", []set{{0, 1, 1}, {1, 1, 2}, {0, 1, 0}}, [][]float64{{0, 0, 0}, {0, 2, 0}}, 1},
+		{"zero of absent element", []set{{1, 2, 0}}, [][]float64{{0, 0, 0}, {0, 0, 0}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := NewSpmat(2, 3)
+			for _, s := range tt.sets {
+				sp.Set(s.i, s.j, s.v)
+			}
+			if err := sp.check(); err != nil {
+				t.Fatal(err)
+			}
+			if got := denseOf(&sp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if len(sp.Col) != tt.nnz {
+				t.Errorf("%d elements stored, want %d", len(sp.Col), tt.nnz)
+			}
+			for i, row := range tt.want {
+				for j, v := range row {
+					if sp.At(i, j) != v {
+						t.Errorf("At(%d, %d) = %v, want %v", i, j, sp.At(i, j), v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMultMatVec(t *testing.T) {
+	tests := []struct {
+		name  string
+		mat   [][]float64
+		ncol  int
+		vin   Vec
+		want  Vec
+		vinT  Vec
+		wantT Vec
+	}{
+		{"identity", [][]float64{{1, 0}, {0, 1}}, 2, Vec{2, 3}, Vec{2, 3}, Vec{2, 3}, Vec{2, 3}},
+		{"rectangular", [][]float64{{1, 0, -1}, {0, 2, 0}}, 3, Vec{1, 2, 3}, Vec{-2, 4}, Vec{1, 2}, Vec{1, 4, -1}},
+		{"empty row", [][]float64{{0, 0}, {1, 1}}, 2, Vec{1, 1}, Vec{0, 2}, Vec{5, 1}, Vec{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mat := spmatOf(tt.mat, tt.ncol)
+			vout := make(Vec, len(tt.want))
+			for i := range vout {
+				vout[i] = 99 // overwritten
+			}
+			MultMatVec(vout, mat, tt.vin)
+			if !reflect.DeepEqual(vout, tt.want) {
+				t.Errorf("MultMatVec = %v, want %v", vout, tt.want)
+			}
+			voutT := make(Vec, len(tt.wantT))
+			MultMatVec_T(voutT, mat, tt.vinT)
+			if !reflect.DeepEqual(voutT, tt.wantT) {
+				t.Errorf("MultMatVec_T = %v, want %v", voutT, tt.wantT)
+			}
+		})
+	}
+}
+
+func TestSwapRowsCols(t *testing.T) {
+	a := [][]float64{{1, 0, 2}, {0, 3, 0}, {4, 0, 0}}
+	b := [][]float64{{0, -1, 0}, {-2, 0, -3}, {0, 0, -4}}
+	tests := []struct {
+		name         string
+		swap         []bool
+		rows         bool
+		want0, want1 [][]float64
+	}{
+		{"rows none", []bool{false, false, false}, true, a, b},
+		{"rows all", []bool{true, true, true}, true, b, a},
+		{"rows middle", []bool{false, true, false}, true,
+			[][]float64{{1, 0, 2}, {-2, 0, -3}, {4, 0, 0}},
+			[][]float64{{0, -1, 0}, {0, 3, 0}, {0, 0, -4}}},
+		{"cols none", []bool{false, false, false}, false, a, b},
+		{"cols all", []bool{true, true, true}, false, b, a},
+		{"cols first", []bool{true, false, false}, false,
+			[][]float64{{0, 0, 2}, {-2, 3, 0}, {0, 0, 0}},
+			[][]float64{{1, -1, 0}, {0, 0, -3}, {4, 0, -4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mat0, mat1 := spmatOf(a, 3), spmatOf(b, 3)
+			if tt.rows {
+				SwapRows(&mat0, &mat1, tt.swap)
+			} else {
+				SwapCols(&mat0, &mat1, tt.swap)
+			}
+			for _, mat := range []*Spmat{&mat0, &mat1} {
+				if err := mat.check(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := denseOf(&mat0); !reflect.DeepEqual(got, tt.want0) {
+				t.Errorf("mat0 = %v, want %v", got, tt.want0)
+			}
+			if got := denseOf(&mat1); !reflect.DeepEqual(got, tt.want1) {
+				t.Errorf("mat1 = %v, want %v", got, tt.want1)
+			}
+		})
+	}
+}
+
+// Offspring of CrossoverSpmats take each row from one parent and the
+// complementary row from the other.
+func TestCrossoverSpmats(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a, b := NewSpmat(20, 30), NewSpmat(20, 30)
+	mag := func(*rand.Rand) float64 { return 1 }
+	a.Randomize(0.3, mag, rng)
+	b.Randomize(0.3, mag, rng)
+	b.Scale(2) // tells the parents apart
+	mat0, mat1 := a.Copy(), b.Copy()
+	CrossoverSpmats(&mat0, &mat1, rng)
+	nswap := 0
+	for i := 0; i < a.NRow(); i++ {
+		ra, rb := denseOf(&a)[i], denseOf(&b)[i]
+		r0, r1 := denseOf(&mat0)[i], denseOf(&mat1)[i]
+		switch {
+		case reflect.DeepEqual(r0, ra) && reflect.DeepEqual(r1, rb):
+		case reflect.DeepEqual(r0, rb) && reflect.DeepEqual(r1, ra):
+			nswap++
+		default:
+			t.Fatalf("row %d: offspring %v, %v from parents %v, %v", i, r0, r1, ra, rb)
+		}
+	}
+	if nswap == 0 || nswap == a.NRow() {
+		t.Errorf("%d of %d rows swapped", nswap, a.NRow())
+	}
+	for _, mat := range []*Spmat{&mat0, &mat1} {
+		if err := mat.check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpmatJSON(t *testing.T) {
+	want := spmatOf([][]float64{{0, 1.5, 0}, {0, 0, 0}, {-1, 0, 2}}, 3)
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"csr", `{"Ncol":3,"RowPtr":[0,1,1,3],"Col":[1,0,2],"Val":[1.5,-1,2]}`},
+		{"maps (before format version 16)", `{"Ncol":3,"Mat":[{"1":1.5},{},{"2":2,"0":-1}]}`},
+		{"maps with zeros", `{"Ncol":3,"Mat":[{"1":1.5,"2":0},{"0":0},{"0":-1,"2":2}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sp Spmat
+			if err := json.Unmarshal([]byte(tt.data), &sp); err != nil {
+				t.Fatal(err)
+			}
+			if err := sp.check(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(denseOf(&sp), denseOf(&want)) {
+				t.Errorf("got %v, want %v", denseOf(&sp), denseOf(&want))
+			}
+			data, err := json.Marshal(sp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != `{"Ncol":3,"RowPtr":[0,1,1,3],"Col":[1,0,2],"Val":[1.5,-1,2]}` {
+				t.Errorf("marshaled as %s", data)
+			}
+		})
+	}
+}
+
+func TestSpmatCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		sp   Spmat
+	}{
+		{"no RowPtr", Spmat{Ncol: 2}},
+		{"RowPtr not from 0", Spmat{Ncol: 2, RowPtr: []int{1, 1}}},
+		{"too few values", Spmat{Ncol: 2, RowPtr: []int{0, 2}, Col: []int{0, 1}, Val: []float64{1}}},
+		{"column out of range", Spmat{Ncol: 2, RowPtr: []int{0, 1}, Col: []int{2}, Val: []float64{1}}},
+		{"columns out of order", Spmat{Ncol: 2, RowPtr: []int{0, 2}, Col: []int{1, 0}, Val: []float64{1, 1}}},
+		{"RowPtr decreasing", Spmat{Ncol: 2, RowPtr: []int{0, 2, 1, 2}, Col: []int{0, 1}, Val: []float64{1, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sp.check(); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
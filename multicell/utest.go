@@ -7,44 +7,58 @@ import (
 	//	"sort"
 )
 
-func TestEqualGenomes(G0, G1 Genome) float64 { //Elementwise difference between two genomes
+func TestEqualGenomes(m *Model, G0, G1 Genome) float64 { //Elementwise difference between two genomes
 	var d float64
 
-	for i := 0; i < ngenes; i++ {
+	if len(m.Layers) > 0 {
+		mats0, mats1 := genomeMats(&G0), genomeMats(&G1)
+		for _, k := range m.activeMats {
+			for i := 0; i < mats0[k].NRow(); i++ {
+				c0, v0 := mats0[k].Row(i)
+				c1, v1 := mats1[k].Row(i)
+				mergeRows(c1, v1, c0, v0, func(j int, a, b float64) {
+					d += math.Abs(a - b)
+				})
+			}
+		}
+		return d
+	}
+
+	for i := 0; i < m.NGenes; i++ {
 
-		if withE {
-			for j := 0; j < nenv+ncells; j++ {
-				d += math.Abs(G1.E.Mat[i][j] - G0.E.Mat[i][j])
+		if m.withE {
+			for j := 0; j < m.NEnv+m.NCells; j++ {
+				d += math.Abs(G1.E.At(i, j) - G0.E.At(i, j))
 			}
 		}
 
-		if withF {
-			for j := 0; j < ngenes; j++ {
-				d += math.Abs(G1.F.Mat[i][j] - G0.F.Mat[i][j])
+		if m.FLayer {
+			for j := 0; j < m.NGenes; j++ {
+				d += math.Abs(G1.F.At(i, j) - G0.F.At(i, j))
 			}
 		}
 
-		for j := 0; j < ngenes; j++ {
-			d += math.Abs(G1.G.Mat[i][j] - G0.G.Mat[i][j])
+		for j := 0; j < m.NGenes; j++ {
+			d += math.Abs(G1.G.At(i, j) - G0.G.At(i, j))
 		}
 
-		if withH {
-			for j := 0; j < ngenes; j++ {
-				d += math.Abs(G1.H.Mat[i][j] - G0.H.Mat[i][j])
+		if m.HLayer {
+			for j := 0; j < m.NGenes; j++ {
+				d += math.Abs(G1.H.At(i, j) - G0.H.At(i, j))
 			}
-			if withJ {
-				for j := 0; j < ngenes; j++ {
-					d += math.Abs(G1.J.Mat[i][j] - G0.J.Mat[i][j])
+			if m.JLayer {
+				for j := 0; j < m.NGenes; j++ {
+					d += math.Abs(G1.J.At(i, j) - G0.J.At(i, j))
 				}
 			}
 		}
 
-		for j := 0; j < nenv+ncells; j++ {
-			d += math.Abs(G1.P.Mat[i][j] - G0.P.Mat[i][j])
+		for j := 0; j < m.NEnv+m.NCells; j++ {
+			d += math.Abs(G1.P.At(i, j) - G0.P.At(i, j))
 		}
 		/*
-			for j := 0; j < ngenes; j++ {
-				d += math.Abs(G1.Z.Mat[i][j] - G0.Z.Mat[i][j])
+			for j := 0; j < m.NGenes; j++ {
+				d += math.Abs(G1.Z.At(i, j) - G0.Z.At(i, j))
 			}
 		*/
 	}
@@ -52,13 +66,13 @@ func TestEqualGenomes(G0, G1 Genome) float64 { //Elementwise difference between
 
 }
 
-func TestEqualPopGenomes(pop0, pop1 Population) float64 { //Test for equal genome across individuals in two populations.
+func TestEqualPopGenomes(m *Model, pop0, pop1 Population) float64 { //Test for equal genome across individuals in two populations.
 	u := 0.0
 	pop0.SortPopIndivs()
 	pop1.SortPopIndivs() //Sort before comparison
 
 	for k, indiv := range pop0.Indivs {
-		u += TestEqualGenomes(indiv.Bodies[0].Genome, pop1.Indivs[k].Bodies[0].Genome) //Update whether individual wise genomes are same
+		u += TestEqualGenomes(m, *indiv.Bodies[0].Genome, *pop1.Indivs[k].Bodies[0].Genome) //Update whether individual wise genomes are same
 	}
 	return u //Warning! Ordering of population individuals is important.
 }
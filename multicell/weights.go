@@ -0,0 +1,98 @@
+package multicell
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Weights of the genome matrices.
+/*
+   WeightModel sets the magnitude of new nonzero weights (at randomization
+   and at mutations that change the topology); their sign is + or - with
+   equal probability:
+     ternary    1 (weights are -1, 0, +1)
+     gaussian   |N(0, WeightSD)|
+     lognormal  exp(N(0, WeightSD))
+   Perturbations change the value of existing weights without changing the
+   topology: each nonzero weight is perturbed with probability PerturbRate
+   per generation, by adding N(0, PerturbStep) (ternary, gaussian) or by
+   multiplying its magnitude by exp(N(0, PerturbStep)) (lognormal). The
+   omegas of the layers are divided by the root mean square of the weights.
+*/
+
+var WeightModels = []string{"ternary", "gaussian", "lognormal"}
+
+// Magnitude of a new nonzero weight.
+func (m *Model) weightMag(rng *rand.Rand) float64 {
+	switch m.WeightModel {
+	case "gaussian":
+		return math.Abs(m.WeightSD * rng.NormFloat64())
+	case "lognormal":
+		return math.Exp(m.WeightSD * rng.NormFloat64())
+	}
+	return 1 // ternary; no random number drawn.
+}
+
+// Root mean square of the weights.
+func (s *Settings) weightRMS() float64 {
+	switch s.WeightModel {
+	case "gaussian":
+		return s.WeightSD
+	case "lognormal":
+		return math.Exp(s.WeightSD * s.WeightSD)
+	}
+	return 1
+}
+
+// Perturbed value of weight w.
+func (m *Model) perturbWeight(w float64, rng *rand.Rand) float64 {
+	if m.WeightModel == "lognormal" {
+		return w * math.Exp(m.PerturbStep*rng.NormFloat64())
+	}
+	return w + m.PerturbStep*rng.NormFloat64()
+}
+
+// Perturbs each nonzero element with probability rate (Poisson number of
+// elements chosen with replacement).
+func (mat *Spmat) perturbSpmat(m *Model, rate float64, rng *rand.Rand) {
+	nnz := len(mat.Val)
+	if rate == 0 || nnz == 0 {
+		return
+	}
+
+	dist := distuv.Poisson{Lambda: rate * float64(nnz), Src: distSource(rng)}
+	nmut := int(dist.Rand())
+	for n := 0; n < nmut; n++ {
+		k := rng.Intn(len(mat.Val))
+		v := m.perturbWeight(mat.Val[k], rng)
+		if v != 0 {
+			mat.Val[k] = v
+			continue
+		}
+		i := sort.Search(len(mat.RowPtr), func(r int) bool { return mat.RowPtr[r] > k }) - 1
+		mat.Set(i, mat.Col[k], 0)
+		if len(mat.Val) == 0 {
+			return
+		}
+	}
+}
+
+func (s *Settings) checkWeights() error {
+	ok := false
+	for _, name := range WeightModels {
+		ok = ok || s.WeightModel == name
+	}
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown weight model %q (known: %v)", s.WeightModel, WeightModels)
+	case s.WeightModel != "ternary" && s.WeightSD <= 0:
+		return fmt.Errorf("weight model %s: WeightSD must be positive", s.WeightModel)
+	case s.PerturbRate < 0 || s.PerturbStep < 0:
+		return fmt.Errorf("PerturbRate and PerturbStep must not be negative")
+	}
+	return nil
+}
@@ -20,7 +20,7 @@ func main() {
 	egflagP := flag.Int("eg", 2, "0: AncEnv; 1: NovEnv; 2: NovEnv - AncEnv")
 	flag.Parse()
 
-	settings := multicell.CurrentSettings()
+	settings := multicell.DefaultSettings()
 	settings.MaxPop = *maxpopP
 	pFlag := *pflagP
 	egFlag := *egflagP
@@ -46,24 +46,28 @@ func main() {
 		log.Fatal("-p must be 0, 1, or 2")
 	}
 
-	pop := multicell.NewPopulation(settings)
+	pop := multicell.NewPopulation(multicell.NewModel(settings))
+	var model *multicell.Model
 	if *jsonP != "" {
-		pop.ImportPopGz(*jsonP)
-		multicell.SetParams(pop.Params)
+		err := pop.ImportPopGz(*jsonP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		model = multicell.NewModel(pop.Params)
 	} else {
 		flag.PrintDefaults()
 		log.Fatal("Specify the input JSON file with -jsonin=filename.")
 	}
 
-	Nenv := multicell.GetNenv()
-	Nsel := multicell.GetNsel()
+	Nenv := model.NEnv
+	Nsel := model.NSel
 
 	fenv0 := multicell.FlattenEnvs(pop.AncEnvs)
 	//	fenv1 := multicell.FlattenEnvs(pop.NovEnvs)
 	lenE := len(fenv0)
 
-	env0 := multicell.FlattenEnvs(multicell.GetSelEnvs(pop.AncEnvs))
-	env1 := multicell.FlattenEnvs(multicell.GetSelEnvs(pop.NovEnvs))
+	env0 := multicell.FlattenEnvs(multicell.GetSelEnvs(model, pop.AncEnvs))
+	env1 := multicell.FlattenEnvs(multicell.GetSelEnvs(model, pop.NovEnvs))
 
 	lenP := len(env0)
 	denv := multicell.NewVec(lenP)
@@ -72,9 +76,14 @@ func main() {
 	paxis := multicell.CopyVec(denv) //paxis is defined as change in environment
 	multicell.NormalizeVec(paxis)
 
-	genome0 := pop.GetFlatGenome(multicell.IAncEnv)
-	genome1 := pop.GetFlatGenome(multicell.INovEnv)
+	genome0 := pop.GetFlatGenome(model, multicell.IAncEnv)
+	genome1 := pop.GetFlatGenome(model, multicell.INovEnv)
 	lenG := len(genome0[0])
+	if egFlag == 2 {
+		if err := pop.RequireDistinctGenomes(); err != nil {
+			log.Fatalf("%v; use -eg=0", err)
+		}
+	}
 	delg := make([][]float64, 0)
 	for k, g := range genome0 {
 		switch egFlag {
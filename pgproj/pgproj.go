@@ -28,10 +28,12 @@ func main() {
 
 	pgfilenamePtr := flag.String("PG_file", "phenogeno", "Filename of projected phenotypes and genotypes")
 	jsongzinPtr := flag.String("jsongzin", "", "basename of JSON files")
+	archivePtr := flag.String("archive", "", "archive file of populations (instead of JSON files)")
+	epochPtr := flag.Int("epoch", 1, "epoch to read from archive")
 
 	flag.Parse()
 
-	settings := multicell.CurrentSettings()
+	settings := multicell.DefaultSettings()
 	settings.MaxPop = *maxpopP
 	settings.MaxDevStep = *maxdevstepP
 	settings.NGenes = *ngenesP
@@ -45,8 +47,18 @@ func main() {
 
 	json_in = *jsongzinPtr
 
-	if json_in == "" {
-		log.Fatal("Must specify JSON input file.")
+	archive_in := *archivePtr
+	if json_in == "" && archive_in == "" {
+		log.Fatal("Must specify JSON input file or archive.")
+	}
+	var archive *multicell.ArchiveReader
+	var err error
+	if archive_in != "" {
+		archive, err = multicell.OpenArchive(archive_in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer archive.Close()
 	}
 	if refgen1 == "" {
 		log.Fatal("Must specify JSON reference file 1.")
@@ -60,21 +72,24 @@ func main() {
 	tdump := time.Now()
 
 	log.Println("Reading Pop0")
-	pop0 := multicell.NewPopulation(settings)
+	pop0 := multicell.NewPopulation(multicell.NewModel(settings))
 	fmt.Println("Reference population :", refgen1)
-	pop0.ImportPopGz(refgen1)
+	err = pop0.ImportPopGz(refgen1)
+	if err != nil {
+		log.Fatal(err)
+	}
 	settings = pop0.Params
-	multicell.SetParams(settings)
+	model0 := multicell.NewModel(settings)
 
-	Nenv := multicell.GetNenv()
-	Nsel := multicell.GetNsel()
+	Nenv := model0.NEnv
+	Nsel := model0.NSel
 
 	// Reference direction (Selective Envs only)
-	env0 := multicell.FlattenEnvs(multicell.GetSelEnvs(pop0.AncEnvs))
-	env1 := multicell.FlattenEnvs(multicell.GetSelEnvs(pop0.NovEnvs))
+	env0 := multicell.FlattenEnvs(multicell.GetSelEnvs(model0, pop0.AncEnvs))
+	env1 := multicell.FlattenEnvs(multicell.GetSelEnvs(model0, pop0.NovEnvs))
 	lenP := len(env0)
 
-	g00 := pop0.GetFlatGenome(multicell.IAncEnv)
+	g00 := pop0.GetFlatGenome(model0, multicell.IAncEnv)
 	e00 := pop0.GetFlatStateVec("E", multicell.IAncEnv, 0, Nenv)
 	if withEnv {
 		for k, e := range e00 {
@@ -82,11 +97,15 @@ func main() {
 		}
 	}
 	log.Println("Reading Pop1")
-	pop1 := multicell.NewPopulation(settings)
+	pop1 := multicell.NewPopulation(model0)
 	fmt.Println("Reference population 2:", refgen2)
-	pop1.ImportPopGz(refgen2)
+	err = pop1.ImportPopGz(refgen2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	model1 := multicell.NewModel(pop1.Params)
 
-	g11 := pop1.GetFlatGenome(multicell.INovEnv)
+	g11 := pop1.GetFlatGenome(model1, multicell.INovEnv)
 	e11 := pop1.GetFlatStateVec("E", multicell.INovEnv, 0, Nenv)
 	if withEnv {
 		for k, e := range e11 {
@@ -127,11 +146,20 @@ func main() {
 		fmt.Fprintf(fout, "#\t Geno+e0     \tPheno0     \tGeno+e1     \tPheno1   ")
 		fmt.Fprintf(fout, "\t||p0-e0||  \t||p1-e1||  \tFit     \tWagFit\n")
 
-		jfilename := fmt.Sprintf("%s_%3.3d.json.gz", json_in, gen)
-		pop := multicell.NewPopulation(settings)
-		pop.ImportPopGz(jfilename)
-		gt0 := pop.GetFlatGenome(0)
-		gt1 := pop.GetFlatGenome(1)
+		var pop multicell.Population
+		if archive != nil {
+			pop, err = archive.Read(*epochPtr, gen)
+		} else {
+			jfilename := fmt.Sprintf("%s_%3.3d.json.gz", json_in, gen)
+			pop = multicell.NewPopulation(model0)
+			err = pop.ImportPopGz(jfilename)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		model := multicell.NewModel(pop.Params)
+		gt0 := pop.GetFlatGenome(model, 0)
+		gt1 := pop.GetFlatGenome(model, 1)
 		et0 := pop.GetFlatStateVec("E", 0, 0, Nenv)
 		et1 := pop.GetFlatStateVec("E", 1, 0, Nenv)
 		if withEnv {
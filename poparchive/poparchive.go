@@ -0,0 +1,124 @@
+package main
+
+// Converts between population archives and json.gz population files.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/arkinjo/evodevo/multicell"
+)
+
+// Epoch and generation in file names written by Evolve in test mode.
+var genFileRE = regexp.MustCompile(`_(\d+)_(\d+)\.json\.gz$`)
+
+func main() {
+	packP := flag.String("pack", "", "Write the given json.gz files to this archive")
+	unpackP := flag.String("unpack", "", "Write the records of the given archive to json.gz files with this basename")
+	listP := flag.Bool("list", false, "List the records of the given archives")
+	epochP := flag.Int("epoch", 0, "Only unpack this epoch (0: all)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -pack run.evar base_01_001.json.gz ...\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -unpack base [-epoch n] run.evar\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s -list run.evar ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case *packP != "":
+		pack(*packP, flag.Args())
+	case *unpackP != "":
+		if flag.NArg() != 1 {
+			log.Fatal("-unpack takes exactly one archive")
+		}
+		unpack(flag.Arg(0), *unpackP, *epochP)
+	case *listP:
+		for _, filename := range flag.Args() {
+			list(filename)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func pack(archname string, filenames []string) {
+	archive, err := multicell.CreateArchive(archname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, filename := range filenames {
+		var pop multicell.Population
+		err = pop.ImportPopGz(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pop.Epoch == 0 { //Files written before Epoch was recorded
+			if m := genFileRE.FindStringSubmatch(filepath.Base(filename)); m != nil {
+				pop.Epoch, _ = strconv.Atoi(m[1])
+				pop.Gen, _ = strconv.Atoi(m[2])
+			}
+		}
+		err = archive.Append(&pop, 0) //full records only (no keyframe interval)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = archive.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d populations written to %s\n", len(filenames), archname)
+}
+
+func unpack(archname, basename string, epoch int) {
+	archive, err := multicell.OpenArchive(archname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer archive.Close()
+
+	for _, e := range archive.Entries() {
+		if epoch != 0 && e.Epoch != epoch {
+			continue
+		}
+		pop, err := archive.Read(e.Epoch, e.Gen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		filename := fmt.Sprintf("%s_%2.2d_%3.3d.json.gz", basename, e.Epoch, e.Gen)
+		err = pop.ExportPopGz(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func list(archname string) {
+	archive, err := multicell.OpenArchive(archname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer archive.Close()
+
+	fmt.Printf("#%s\n#Epoch\tGen\tOffset\tLength\tKind\n", archname)
+	for _, e := range archive.Entries() {
+		kind := "full"
+		if e.Delta {
+			kind = "delta"
+		}
+		fmt.Printf("%d\t%d\t%d\t%d\t%s\n", e.Epoch, e.Gen, e.Offset, e.Length, kind)
+	}
+}
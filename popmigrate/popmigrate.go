@@ -0,0 +1,60 @@
+package main
+
+// Converts population files from older layouts to the current one.
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/arkinjo/evodevo/multicell"
+)
+
+func main() {
+	checkP := flag.Bool("check", false, "Only report whether the files need migration")
+	suffixP := flag.String("suffix", "", "Write each output to input+suffix instead of overwriting the input")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] file.json.gz ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	nfail := 0
+	for _, filename := range flag.Args() {
+		fin, err := os.Open(filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pop, notes, err := multicell.MigratePopulation(fin)
+		fin.Close()
+		if err != nil {
+			fmt.Printf("%s: refused: %v\n", filename, err)
+			nfail++
+			continue
+		}
+		if len(notes) == 0 {
+			fmt.Printf("%s: up to date (version %d)\n", filename, pop.FormatVersion)
+			continue
+		}
+		for _, note := range notes {
+			fmt.Printf("%s: %s\n", filename, note)
+		}
+		if *checkP {
+			continue
+		}
+		outname := filename + *suffixP
+		err = pop.ExportPopGz(outname)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if nfail > 0 {
+		os.Exit(1)
+	}
+}
@@ -29,55 +29,16 @@ REF1=001
 REF2=200
 TAUF=1
 
-# default values
-if [ "${base}" = "E_G__P" ]; then
-#    Single-layer with fat hidden layer. [NoHier]
-    NGENES=600
-    DENSITY_E=$((0.02/3))
-    DENSITY_G=$((4*0.02/9))
-    DENSITY_H=0.00
-    DENSITY_P=$((0.02/3))
-elif [ "${base}" = "EFGH__" ]; then
-#   "Feedforward" deep model [NoDev]
-    NGENES=200
-    DENSITY_E=0.02
-    DENSITY_G=0.02
-    DENSITY_H=0.04
-    DENSITY_P=0.02
-elif [ "${base}" = "__G___" ]; then
-#    Single fat layer "feedforward" model. [Null]   
-    NGENES = 800
-    DENSITY_E=0.00
-    DENSITY_G=$((5*0.02/16))
-    DENSITY_H=0.00
-    DENSITY_P=$((0.02/4))
-else
-#   Everything else
-    NGENES=200
-    DENSITY_E=0.02
-    DENSITY_G=0.02
-    DENSITY_H=0.02
-    DENSITY_P=0.02
-fi
-
-
-if [ ${base[1]} = "_" ]; then e=F; else e=T; fi
-if [ ${base[2]} = "_" ]; then f=F; else f=T; fi
-if [ ${base[4]} = "_" ]; then h=F; else h=T; fi
-if [ ${base[5]} = "_" ]; then j=F; else j=T; fi
-if [ ${base[6]} = "_" ]; then p=F; else p=T; fi
-
-echo $base ${e}${f}G${h}${j}${p}
+# Layers, number of genes and densities of ${base} are set by the preset of train.
+echo $base
 
 if [ ${DENV2} -eq 100 ]; then
     $train -maxpop=${MAXPOP} -ncells=${NCELLS} -nepoch=${NEPOCH1} \
 	   -traj_file=traj/${base}_train.traj \
 	   -jsongzout=json/${base}_train.json.gz \
 	   -denv=${DENV1} -noise=${NOISE} -mut=${MUT} \
-	   -cue=${e} -layerF=${f} -layerH=${h} -layerJ=${j} \
-	   -pfback=${p} \
+	   -preset=${base} \
 	   -seed=${SEED1} -seed_cue=${SEEDCUE1} \
-	   -ngenes=${NGENES} -dE=${DENSITY_E} -dG=${DENSITY_G} -dH=${DENSITY_H} -dP=${DENSITY_P} \
 	   -tauF=${TAUF} \
 	   > /dev/null
 fi
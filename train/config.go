@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/arkinjo/evodevo/multicell"
+)
+
+// Configuration of a training run (train -config=run.json).
+/*
+   Values are resolved in this order, later ones taking precedence:
+     defaults of the flags
+     Preset of the config file (layers, ngenes, and densities)
+     other fields of the config file (absent fields keep their values)
+     -preset on the command line
+     other flags given on the command line
+   The resolved configuration is written next to the output population.
+*/
+type RunConfig struct {
+	Preset     string `json:",omitempty"` // See multicell.Presets
+	Settings   multicell.Settings
+	Seed       int
+	SeedCue    int
+	NEpoch     int
+	NGen       int
+	Denv       int
+	Test       bool
+	TrajFile   string
+	JSONIn     string
+	JSONOut    string
+	Archive    string
+	Keyframe   int
+	Checkpoint string
+}
+
+func defaultConfig() RunConfig {
+	s := multicell.DefaultSettings()
+	s.MaxPop = 1000
+	s.JLayer = true
+	s.Pfback = true
+
+	return RunConfig{Settings: s, Seed: 13, SeedCue: 7, NEpoch: 20, NGen: 200, Denv: 100,
+		TrajFile: "traj.dat", JSONOut: "popout"}
+}
+
+func (cfg *RunConfig) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var head struct{ Preset string }
+	err = json.Unmarshal(data, &head)
+	if err != nil {
+		return err
+	}
+	if head.Preset != "" {
+		cfg.Preset = head.Preset
+		err = cfg.Settings.SetPreset(head.Preset)
+		if err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() //catch misspelled fields
+	return dec.Decode(cfg)
+}
+
+func (cfg *RunConfig) Save(filename string) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Where the resolved configuration is written: next to the output population.
+func (cfg *RunConfig) resolvedName() string {
+	return strings.TrimSuffix(cfg.JSONOut, ".json.gz") + ".config.json"
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/arkinjo/evodevo/multicell"
@@ -14,114 +16,284 @@ var T_Filename string = "traj.dat"
 var jsongz_in string //gzip compressed JSON encoding of initial population; default to empty string
 var jsongz_out string = "popout"
 var jfilename string
+var checkpoint_out string //checkpoint of the run; default to empty string (no checkpoint)
+var resume_in string      //checkpoint to resume from
+var archive_out string    //archive of populations in test mode; default to empty string (json.gz files)
+var keyframe int          //interval of full records in archive; 0: full records only
 
 func main() {
 	t0 := time.Now()
-	testP := flag.Bool("test", false, "Test run or not")
-	maxpopP := flag.Int("maxpop", 1000, "maximum number of individuals in population")
-	maxdevstepP := flag.Int("maxdevstep", 200, "maximum number of steps for development")
-	ngenesP := flag.Int("ngenes", 200, "Number of genes")
-	nenvP := flag.Int("nenv", 200, "Number of environmental cues/traits")
-	nselP := flag.Int("nsel", 40, "Number of environmental cues/traits for selection")
-	ncellsP := flag.Int("ncells", 1, "Number of cell types")
-	withcueP := flag.Bool("cue", true, "With environmental cue")
-	flayerP := flag.Bool("layerF", true, "Epigenetic layer")
-	hlayerP := flag.Bool("layerH", true, "Higher order complexes")
-	jlayerP := flag.Bool("layerJ", true, "Interactions in higher order interactions")
-	pfbackP := flag.Bool("pfback", true, "Phenotype feedback to input")
-
-	noiseP := flag.Float64("noise", 0.05, "Strength of environmental noise")
-	mutP := flag.Float64("mut", 0.005, "Mutation rate")
-	tauFP := flag.Float64("tauF", 0.2, "Decay rate of the f layer")
-	denEP := flag.Float64("dE", 0.02, "Density of E")
-	denFP := flag.Float64("dF", 0.02, "Density of F")
-	denGP := flag.Float64("dG", 0.02, "Density of G")
-	denHP := flag.Float64("dH", 0.02, "Density of H")
-	denJP := flag.Float64("dJ", 0.02, "Density of J")
-	denPP := flag.Float64("dP", 0.02, "Density of P")
-
-	seedPtr := flag.Int("seed", 13, "random seed")
-	seed_cuePtr := flag.Int("seed_cue", 7, "random seed for environmental cue")
-	epochPtr := flag.Int("nepoch", 20, "number of epochs")
-	genPtr := flag.Int("ngen", 200, "number of generation/epoch")
-
-	denvPtr := flag.Int("denv", 100, "magnitude of environmental change")
-	tfilenamePtr := flag.String("traj_file", "traj.dat", "filename of trajectories")
-	jsongzinPtr := flag.String("jsongzin", "", "json file of input population") //default to empty string
-	jsongzoutPtr := flag.String("jsongzout", "popout", "json file of output population")
+	cfg := defaultConfig()
+	settings := &cfg.Settings
+	configP := flag.String("config", "", "JSON file of run configuration (flags given on the command line take precedence)")
+	flag.StringVar(&cfg.Preset, "preset", "", "layer architecture: "+strings.Join(multicell.Presets, ", ")+", ... (sets layers, ngenes and densities)")
+	flag.BoolVar(&cfg.Test, "test", cfg.Test, "Test run or not")
+	flag.IntVar(&settings.MaxPop, "maxpop", settings.MaxPop, "maximum number of individuals in population")
+	flag.IntVar(&settings.MaxDevStep, "maxdevstep", settings.MaxDevStep, "maximum number of steps for development")
+	flag.IntVar(&settings.NGenes, "ngenes", settings.NGenes, "Number of genes")
+	flag.IntVar(&settings.NEnv, "nenv", settings.NEnv, "Number of environmental cues/traits")
+	flag.IntVar(&settings.NSel, "nsel", settings.NSel, "Number of environmental cues/traits for selection")
+	flag.IntVar(&settings.NCells, "ncells", settings.NCells, "Number of cell types")
+	flag.BoolVar(&settings.WithCue, "cue", settings.WithCue, "With environmental cue")
+	flag.BoolVar(&settings.FLayer, "layerF", settings.FLayer, "Epigenetic layer")
+	flag.BoolVar(&settings.HLayer, "layerH", settings.HLayer, "Higher order complexes")
+	flag.BoolVar(&settings.JLayer, "layerJ", settings.JLayer, "Interactions in higher order interactions")
+	flag.BoolVar(&settings.Pfback, "pfback", settings.Pfback, "Phenotype feedback to input")
+
+	flag.Float64Var(&settings.SDNoise, "noise", settings.SDNoise, "Strength of environmental noise")
+	flag.Float64Var(&settings.MutRate, "mut", settings.MutRate, "Mutation rate")
+	flag.Float64Var(&settings.TauF, "tauF", settings.TauF, "Decay rate of the f layer")
+	flag.Float64Var(&settings.DensityE, "dE", settings.DensityE, "Density of E")
+	flag.Float64Var(&settings.DensityF, "dF", settings.DensityF, "Density of F")
+	flag.Float64Var(&settings.DensityG, "dG", settings.DensityG, "Density of G")
+	flag.Float64Var(&settings.DensityH, "dH", settings.DensityH, "Density of H")
+	flag.Float64Var(&settings.DensityJ, "dJ", settings.DensityJ, "Density of J")
+	flag.Float64Var(&settings.DensityP, "dP", settings.DensityP, "Density of P")
+	acts := strings.Join(multicell.ActivationNames(), ", ")
+	flag.StringVar(&settings.ActF, "actF", settings.ActF, "activation function of the f layer: "+acts)
+	flag.StringVar(&settings.ActG, "actG", settings.ActG, "activation function of the g layer")
+	flag.StringVar(&settings.ActH, "actH", settings.ActH, "activation function of the h layer")
+	flag.StringVar(&settings.ActP, "actP", settings.ActP, "activation function of the phenotype")
+	flag.StringVar(&settings.WeightModel, "weights", settings.WeightModel, "weight model: "+strings.Join(multicell.WeightModels, ", "))
+	flag.Float64Var(&settings.WeightSD, "weightSD", settings.WeightSD, "spread of weight magnitudes (gaussian, lognormal)")
+	flag.Float64Var(&settings.PerturbRate, "perturb", settings.PerturbRate, "probability of perturbing a nonzero weight per generation")
+	flag.Float64Var(&settings.PerturbStep, "perturbStep", settings.PerturbStep, "size of weight perturbations")
+	flag.Float64Var(&settings.MutRateE, "mutE", settings.MutRateE, "Mutation rate of E (0: -mut)")
+	flag.Float64Var(&settings.MutRateF, "mutF", settings.MutRateF, "Mutation rate of F (0: -mut)")
+	flag.Float64Var(&settings.MutRateG, "mutG", settings.MutRateG, "Mutation rate of G (0: -mut)")
+	flag.Float64Var(&settings.MutRateH, "mutH", settings.MutRateH, "Mutation rate of H (0: -mut)")
+	flag.Float64Var(&settings.MutRateJ, "mutJ", settings.MutRateJ, "Mutation rate of J (0: -mut)")
+	flag.Float64Var(&settings.MutRateP, "mutP", settings.MutRateP, "Mutation rate of P (0: -mut)")
+	flag.StringVar(&settings.Frozen, "frozen", settings.Frozen, "matrices that are neither mutated nor crossed over: letters (e.g. FGHJP) or comma-separated names (e.g. P,Q)")
+	flag.StringVar(&settings.Signal, "signal", settings.Signal, "layer whose state the cells send to each other (e.g. g; empty: no signaling)")
+	flag.Float64Var(&settings.DensityS, "dS", settings.DensityS, "Density of S (signal)")
+	flag.IntVar(&settings.NSignal, "nsignal", settings.NSignal, "number of units of the signal layer sent (0: all)")
+	flag.IntVar(&settings.Lattice, "lattice", settings.Lattice, "cells on a lattice with morphogens: 0 (none), 1 (chain), 2 (grid)")
+	flag.IntVar(&settings.LatticeW, "latticeW", settings.LatticeW, "number of columns of the grid")
+	flag.Float64Var(&settings.Diffusion, "diffusion", settings.Diffusion, "diffusion coefficient of morphogens")
+	flag.Float64Var(&settings.MorphDecay, "morphDecay", settings.MorphDecay, "relaxation rate of morphogens")
+	flag.Float64Var(&settings.MorphSource, "morphSource", settings.MorphSource, "production of morphogen 0 at x = 0")
+	flag.StringVar(&settings.Pattern, "pattern", settings.Pattern, "spatial pattern of selected traits: stripes, gradient")
+	flag.StringVar(&settings.DevEngine, "engine", settings.DevEngine, "developmental engine: "+strings.Join(multicell.DevEngines, ", "))
+	flag.Float64Var(&settings.DevDt, "dt", settings.DevDt, "(initial) time step of the rk4 and dopri engines")
+	flag.Float64Var(&settings.DevTol, "devtol", settings.DevTol, "error tolerance of the dopri engine")
+	flag.StringVar(&settings.DevNoise, "devnoise", settings.DevNoise, "intrinsic noise of development: "+strings.Join(multicell.DevNoises, ", "))
+	flag.Float64Var(&settings.DevNoiseSD, "devnoiseSD", settings.DevNoiseSD, "strength of intrinsic noise (per unit time)")
+	flag.IntVar(&settings.NEnvs, "nenvs", settings.NEnvs, "number of environments each individual develops in (Anc, Nov, and further ones)")
+	flag.BoolVar(&settings.SharedGenome, "shared", settings.SharedGenome, "all bodies of an individual develop one genome")
+	flag.StringVar(&settings.FitAgg, "fitagg", settings.FitAgg, "fitness over the environments: "+strings.Join(multicell.FitAggs, ", "))
+	flag.StringVar(&settings.Fitness.Func, "fitness", settings.Fitness.Func, "fitness function: "+strings.Join(multicell.FitnessNames(), ", "))
+	flag.Float64Var(&settings.Fitness.Threshold, "threshold", settings.Fitness.Threshold, "maximum error of truncation selection")
+	flag.StringVar(&settings.Fitness.DevCost, "devcost", settings.Fitness.DevCost, "cost of development in fitness: "+strings.Join(multicell.DevCosts, ", "))
+	flag.StringVar(&settings.Selection, "selection", settings.Selection, "selection scheme: "+strings.Join(multicell.SelectionNames(), ", "))
+	flag.IntVar(&settings.Tournament, "tournament", settings.Tournament, "size of tournaments")
+	flag.Float64Var(&settings.TruncFrac, "truncfrac", settings.TruncFrac, "fraction of the population selected by truncation")
+	flag.IntVar(&settings.Elite, "elite", settings.Elite, "number of the fittest individuals copied unchanged to the next generation")
+	flag.StringVar(&settings.Recombination, "recomb", settings.Recombination, "recombination: "+strings.Join(multicell.RecombinationNames(), ", "))
+	flag.Float64Var(&settings.CrossRate, "crossrate", settings.CrossRate, "probability that a mating recombines")
+	flag.IntVar(&settings.CrossPoints, "crosspoints", settings.CrossPoints, "number of crossover points (kpoint)")
+	flag.Float64Var(&settings.RecombRate, "recombrate", settings.RecombRate, "probability of crossover between adjacent genes (linkage)")
+
+	flag.IntVar(&cfg.Seed, "seed", cfg.Seed, "random seed")
+	flag.IntVar(&cfg.SeedCue, "seed_cue", cfg.SeedCue, "random seed for environmental cue")
+	flag.IntVar(&cfg.NEpoch, "nepoch", cfg.NEpoch, "number of epochs")
+	flag.IntVar(&cfg.NGen, "ngen", cfg.NGen, "number of generation/epoch")
+
+	flag.IntVar(&cfg.Denv, "denv", cfg.Denv, "magnitude of environmental change")
+	flag.StringVar(&cfg.TrajFile, "traj_file", cfg.TrajFile, "filename of trajectories")
+	flag.StringVar(&cfg.JSONIn, "jsongzin", cfg.JSONIn, "json file of input population") //default to empty string
+	flag.StringVar(&cfg.JSONOut, "jsongzout", cfg.JSONOut, "json file of output population")
+	flag.StringVar(&cfg.Checkpoint, "checkpoint", cfg.Checkpoint, "checkpoint file written at the end of every epoch")
+	resumePtr := flag.String("resume", "", "checkpoint file to resume an interrupted run from")
+	flag.StringVar(&cfg.Archive, "archive", cfg.Archive, "archive file of populations of every generation (test mode) instead of json.gz files")
+	flag.IntVar(&cfg.Keyframe, "keyframe", cfg.Keyframe, "store generations in archive as deltas from parents, with a full record every n generations (0: full records only)")
 	flag.Parse()
 
-	settings := multicell.CurrentSettings()
-	settings.MaxPop = *maxpopP
-	settings.MaxDevStep = *maxdevstepP
-	settings.NGenes = *ngenesP
-	settings.NEnv = *nenvP
-	settings.NSel = *nselP
-	settings.NCells = *ncellsP
-	settings.WithCue = *withcueP
-	settings.WithCue = *withcueP
-	settings.FLayer = *flayerP
-	settings.HLayer = *hlayerP
-	settings.JLayer = *jlayerP
-	settings.Pfback = *pfbackP
-	settings.SDNoise = *noiseP
-	settings.MutRate = *mutP
-	settings.TauF = *tauFP
-	settings.DensityE = *denEP
-	settings.DensityF = *denFP
-	settings.DensityG = *denGP
-	settings.DensityH = *denHP
-	settings.DensityJ = *denJP
-	settings.DensityP = *denPP
-
-	log.Println("seed=", *seedPtr, "seed_cue=", *seed_cuePtr)
-	multicell.SetSeed(int64(*seedPtr))
-	multicell.SetSeedCue(int64(*seed_cuePtr))
-
-	maxepochs := *epochPtr
-	epochlength := *genPtr
-	denv := *denvPtr
-	T_Filename = *tfilenamePtr
-	jsongz_in = *jsongzinPtr
-	jsongz_out = *jsongzoutPtr
-	test_flag := *testP
-
-	pop0 := multicell.NewPopulation(settings)
-
-	if jsongz_in != "" { //read input population as a .json.gz file, if given
-		pop0.ImportPopGz(jsongz_in)
+	presetSet := false
+	flag.Visit(func(f *flag.Flag) { presetSet = presetSet || f.Name == "preset" })
+	if *configP != "" || presetSet { //resolve config file and preset, then reapply the flags given
+		preset := cfg.Preset
+		cfg = defaultConfig()
+		if *configP != "" {
+			if err := cfg.Load(*configP); err != nil {
+				log.Fatal(*configP, ": ", err)
+			}
+		}
+		if presetSet {
+			cfg.Preset = preset
+			if err := cfg.Settings.SetPreset(preset); err != nil {
+				log.Fatal(err)
+			}
+		}
+		flag.CommandLine.Parse(os.Args[1:])
 	}
 
-	pop0.Params.SDNoise = settings.SDNoise
-	pop0.Params.MutRate = settings.MutRate
-	multicell.SetParams(pop0.Params)
-	if jsongz_in == "" {
-		fmt.Println("Randomizing initial population")
-		pop0.RandomizeGenome()
-	}
+	log.Println("seed=", cfg.Seed, "seed_cue=", cfg.SeedCue)
 
-	ftraj, err := os.OpenFile(T_Filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644) //create file for recording trajectory
-	if err != nil {
-		log.Fatal(err)
-	}
+	maxepochs := cfg.NEpoch
+	epochlength := cfg.NGen
+	denv := cfg.Denv
+	T_Filename = cfg.TrajFile
+	jsongz_in = cfg.JSONIn
+	jsongz_out = cfg.JSONOut
+	checkpoint_out = cfg.Checkpoint
+	resume_in = *resumePtr
+	archive_out = cfg.Archive
+	keyframe = cfg.Keyframe
+	test_flag := cfg.Test
+
+	var popstart multicell.Population
+	var model *multicell.Model
+	var ftraj *os.File
+	var archive *multicell.ArchiveWriter
+	var err error
+	envtraj := make([]multicell.Cues, 1) //Trajectory of environment cue
+	novvec := make([]bool, 0)
+	epoch0 := 1
 
-	popstart := pop0
-	if jsongz_in != "" {
-		popstart.ChangeEnvs(denv)
+	if resume_in != "" { //continue an interrupted run from its checkpoint
+		ck, err := multicell.ImportCheckpoint(resume_in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		popstart = ck.Pop
+		model = multicell.NewModel(popstart.Params)
+		ck.RestoreRandStates(model)
+		maxepochs = ck.NEpoch
+		epochlength = ck.NGen
+		denv = ck.Denv
+		test_flag = ck.Test
+		T_Filename = ck.TrajFile
+		jsongz_out = ck.JSONOut
+		archive_out = ck.Archive
+		keyframe = ck.Keyframe
+		envtraj = ck.EnvTraj
+		novvec = ck.NovVec
+		epoch0 = ck.Epoch + 1
+		if checkpoint_out == "" {
+			checkpoint_out = resume_in
+		}
+
+		ftraj, err = os.OpenFile(T_Filename, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = ftraj.Truncate(ck.TrajOffset) //discard the interrupted epoch
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = ftraj.Seek(ck.TrajOffset, io.SeekStart)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if test_flag && archive_out != "" {
+			archive, err = multicell.AppendArchive(archive_out, ck.Epoch) //discard the interrupted epoch
+			if err != nil {
+				log.Fatal(err)
+			}
+			archive.SetKeyframe(keyframe)
+		}
+		fmt.Println("Resuming from epoch", epoch0)
 	} else {
-		popstart.SetRandomNovEnvs()
+		model0 := multicell.NewModel(*settings)
+		model0.SetSeed(int64(cfg.Seed))
+		model0.SetSeedCue(int64(cfg.SeedCue))
+		pop0 := multicell.NewPopulation(model0)
+
+		if jsongz_in != "" { //read input population as a .json.gz file, if given
+			err = pop0.ImportPopGz(jsongz_in)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		flags := make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+		prov := multicell.NewProvenance(int64(cfg.Seed), int64(cfg.SeedCue), flags)
+		if jsongz_in != "" {
+			err = prov.SetParent(jsongz_in, &pop0)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		pop0.Provenance = prov
+
+		pop0.Params.SDNoise = settings.SDNoise
+		pop0.Params.MutRate = settings.MutRate
+		pop0.Params.PerturbRate = settings.PerturbRate
+		pop0.Params.PerturbStep = settings.PerturbStep
+		pop0.Params.MutRateE = settings.MutRateE
+		pop0.Params.MutRateF = settings.MutRateF
+		pop0.Params.MutRateG = settings.MutRateG
+		pop0.Params.MutRateH = settings.MutRateH
+		pop0.Params.MutRateJ = settings.MutRateJ
+		pop0.Params.MutRateP = settings.MutRateP
+		pop0.Params.Frozen = settings.Frozen
+		pop0.Params.MutRates = settings.MutRates
+		pop0.Params.DevNoise = settings.DevNoise
+		pop0.Params.DevNoiseSD = settings.DevNoiseSD
+		pop0.Params.FitAgg = settings.FitAgg
+		pop0.Params.Fitness = settings.Fitness
+		pop0.Params.Selection = settings.Selection
+		pop0.Params.Tournament = settings.Tournament
+		pop0.Params.TruncFrac = settings.TruncFrac
+		pop0.Params.Elite = settings.Elite
+		pop0.Params.Recombination = settings.Recombination
+		pop0.Params.CrossRate = settings.CrossRate
+		pop0.Params.CrossPoints = settings.CrossPoints
+		pop0.Params.RecombRate = settings.RecombRate
+		pop0.Params.LinkageMap = settings.LinkageMap
+		model = multicell.NewModel(pop0.Params)
+		model.ShareRunState(model0) //continue the random numbers of the run
+		if jsongz_in == "" {
+			fmt.Println("Randomizing initial population")
+			pop0.RandomizeGenome(model)
+		}
+
+		cfg.Settings = pop0.Params //as used, including those of the input population
+		err = cfg.Save(cfg.resolvedName())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Resolved configuration written to", cfg.resolvedName())
+
+		ftraj, err = os.OpenFile(T_Filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644) //create file for recording trajectory
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if test_flag && archive_out != "" {
+			archive, err = multicell.CreateArchive(archive_out)
+			if err != nil {
+				log.Fatal(err)
+			}
+			archive.SetKeyframe(keyframe)
+		}
+
+		popstart = pop0
+		if jsongz_in != "" {
+			popstart.ChangeEnvs(model, denv)
+		} else {
+			popstart.SetRandomNovEnvs(model)
+		}
+		envtraj[0] = popstart.AncEnvs
 	}
 
 	fmt.Println("Initialization of population complete")
 	dtint := time.Since(t0)
 	fmt.Println("Time taken for initialization : ", dtint)
 
-	envtraj := make([]multicell.Cues, 1) //Trajectory of environment cue
-	envtraj[0] = popstart.AncEnvs
-	novvec := make([]bool, 0)
+	observers := []multicell.Observer{multicell.TrajWriter{W: ftraj}, multicell.ProgressPrinter{}}
+	if test_flag { //Record population of each generation in test mode
+		if archive != nil {
+			observers = append(observers, archive)
+		} else if jsongz_out != "" {
+			observers = append(observers, multicell.PopExporter{Basename: jsongz_out})
+		}
+	}
 
-	log.Println("AncEnvs", 0, ":", popstart.AncEnvs)
-	for epoch := 1; epoch <= maxepochs; epoch++ {
+	log.Println("AncEnvs", 0, ":", envtraj[0])
+	for epoch := epoch0; epoch <= maxepochs; epoch++ {
 		tevol := time.Now()
 		log.Println("NovEnvs", epoch, ":", popstart.NovEnvs)
 		envtraj = append(envtraj, popstart.NovEnvs)
@@ -129,27 +301,56 @@ func main() {
 			fmt.Println("Epoch ", epoch, "has environments", popstart.NovEnvs)
 		}
 
-		pop1 := popstart.Evolve(test_flag, ftraj, jsongz_out, epochlength, epoch)
+		pop1, err := popstart.Evolve(model, epochlength, epoch, observers...)
+		if err != nil {
+			log.Fatal(err)
+		}
 		fmt.Println("End of epoch", epoch)
 
 		if !test_flag && epoch == maxepochs { //Export output population; just before epoch change
-			pop1.ExportPopGz(jsongz_out)
+			err = pop1.ExportPopGz(jsongz_out)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 		dtevol := time.Since(tevol)
 		fmt.Println("Time taken to simulate evolution :", dtevol)
 
 		popstart = pop1 //Update population after evolution.
-		popstart.ChangeEnvs(denv)
+		popstart.ChangeEnvs(model, denv)
 		err = multicell.DeepVec3NovTest(popstart.NovEnvs, envtraj)
 		if err != nil {
 			fmt.Println(err)
 		}
 		novvec = append(novvec, err == nil)
+
+		if checkpoint_out != "" {
+			offset, err := ftraj.Seek(0, io.SeekCurrent)
+			if err != nil {
+				log.Fatal(err)
+			}
+			ck := multicell.Checkpoint{Pop: popstart, Epoch: epoch,
+				NEpoch: maxepochs, NGen: epochlength, Denv: denv, Test: test_flag,
+				TrajFile: T_Filename, TrajOffset: offset, JSONOut: jsongz_out, Archive: archive_out, Keyframe: keyframe,
+				EnvTraj: envtraj, NovVec: novvec}
+			ck.SetRandStates(model)
+			err = ck.ExportCheckpoint(checkpoint_out)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
 	err = ftraj.Close()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if archive != nil {
+		err = archive.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Populations of every generation archived in %s \n", archive_out)
+	}
 
 	fmt.Printf("Trajectory of population written to %s \n", T_Filename)
 	fmt.Printf("JSON encoding of evolved population written to %s \n", jfilename)